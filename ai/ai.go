@@ -1,13 +1,47 @@
 package ai
 
 import (
+    "errors"
     //"fmt"
     "math"
     //"sort"
     "time"
 )
 
-func Loop(me int, history *[]*Board, inChan chan bool, outChan chan bool, depth int, timeMillis int, nTop int) {
+type Algorithm int
+
+const (
+    AlphaBeta Algorithm = iota
+    MCTS
+)
+
+func ParseAlgorithm(name string) (Algorithm, error) {
+    switch name {
+        case "", "alphabeta": return AlphaBeta, nil
+        case "mcts": return MCTS, nil
+    }
+    return AlphaBeta, errors.New("Unknown algorithm " + name)
+}
+
+func (algo Algorithm) String() string {
+    if algo == MCTS {
+        return "mcts"
+    }
+    return "alphabeta"
+}
+
+// Algorithms are written by name in JSON
+func (algo Algorithm) MarshalText() ([]byte, error) {
+    return []byte(algo.String()), nil
+}
+
+func (algo *Algorithm) UnmarshalText(text []byte) error {
+    a, err := ParseAlgorithm(string(text))
+    *algo = a
+    return err
+}
+
+func Loop(me int, history *[]*Board, inChan chan bool, outChan chan bool, depth int, timeMillis int, nTop int, algo Algorithm) {
     var board *Board
     for { 
         state := <- inChan 
@@ -17,10 +51,15 @@ func Loop(me int, history *[]*Board, inChan chan bool, outChan chan bool, depth
         if !state || board.GameOver(*history) {
             break
         }
-        next := Search(*history, me, depth, timeMillis, nTop)
+        next := Search(*history, me, depth, timeMillis, nTop, algo)
         if next == nil {
-            time.Sleep(100 * time.Millisecond)
-            continue
+            if board.Turn % board.NPlayers != me {
+                time.Sleep(100 * time.Millisecond)
+                continue
+            }
+            // Out of time before finding anything
+            next = board.Clone()
+            next.Turn += 1
         }
         *history = append(*history, next)
         outChan <- true
@@ -28,20 +67,38 @@ func Loop(me int, history *[]*Board, inChan chan bool, outChan chan bool, depth
 }
 
 // Set up iterative deepening
-func Search(history []*Board, me int, depth int, timeMillis int, nTop int) *Board {
+// Or hand the whole time budget to MCTS
+func Search(history []*Board, me int, depth int, timeMillis int, nTop int, algo Algorithm) *Board {
     startTime := time.Now()
     board := history[len(history)-1]
+    if board.Turn % board.NPlayers != me {
+        return nil
+    }
     stats := board.GetStats()
     // End early if you win right away
-    if len(history) >= 2 && board.Equals(history[len(history)-2]) && stats.Scores[me] > stats.Scores[1-me] {
-        res := board.Clone()
-        res.Turn += 1
-        return res
+    if len(history) >= 2 && board.Equals(history[len(history)-2]) {
+        scores := board.GetFinalScores()
+        winning := true
+        for i, score := range scores {
+            if i != me && score >= scores[me] {
+                winning = false
+            }
+        }
+        if winning {
+            res := board.Clone()
+            res.Turn += 1
+            return res
+        }
+    }
+    if algo == MCTS {
+        return SearchMCTS(history, me, timeMillis)
     }
     var res *Board
+    // Shared across depths for move ordering and bounds
+    tt := make(TransTable)
     for d := 1; d < depth; d++ {
         //_, fn, fin := SearchDeep(stats, history, me, d, startTime, timeMillis, nTop)
-        _, fn, fin, _ := SearchDeepAlphaBeta(stats, history, me, d, math.Inf(-1), math.Inf(1), true, startTime, timeMillis)
+        _, fn, fin, _ := SearchDeepAlphaBeta(stats, history, me, d, math.Inf(-1), math.Inf(1), startTime, timeMillis, tt)
         /*if st != nil {
             if d == 1 {
                 fmt.Println("board", board)
@@ -71,21 +128,41 @@ func min(a float64, b float64) float64 {
     return b
 }
 
-func SearchDeepAlphaBeta(stats *Stats, history []*Board, me int, depth int, alpha float64, beta float64, maxNotMin bool, startTime time.Time, timeMillis int) (*Board, func()*Board, bool, float64) {
+func SearchDeepAlphaBeta(stats *Stats, history []*Board, me int, depth int, alpha float64, beta float64, startTime time.Time, timeMillis int, tt TransTable) (*Board, func()*Board, bool, float64) {
+    board := history[len(history)-1]
+    // Eval only looks at the position
     if depth == 0 {
-        return history[len(history)-1], nil, true, history[len(history)-1].Eval(stats, me)
+        key := board.Key()
+        if entry := tt[key]; entry != nil && entry.flag == ttExact {
+            return board, nil, true, entry.value
+        }
+        val := board.Eval(stats, me)
+        tt[key] = &ttEntry{depth: 0, value: val, flag: ttExact}
+        return board, nil, true, val
     }
     if time.Since(startTime).Milliseconds() > int64(timeMillis) {
         return nil, nil, false, 0
     }
-    board := history[len(history)-1]
-    var me2 int
-    if maxNotMin {
-        me2 = me
-    } else {
-        me2 = 1-me
+    // Paranoid search: with more than two players
+    // every opponent minimizes my evaluation in turn
+    me2 := board.Turn % board.NPlayers
+    maxNotMin := me2 == me
+    fns, banned := board.getCandidates(history, me2)
+    // The moves below depend on the ko and pass state, not just the position
+    key := historyKey(history, banned)
+    entry := tt[key]
+    // Reuse bounds from earlier iterations or transpositions
+    if entry != nil && entry.depth >= depth {
+        switch entry.flag {
+            case ttExact: return nil, nil, true, entry.value
+            case ttLower: alpha = max(alpha, entry.value)
+            case ttUpper: beta = min(beta, entry.value)
+        }
+        if alpha >= beta {
+            return nil, nil, true, entry.value
+        }
     }
-    fns := board.GetCandidates(history, me2)
+    alphaOrig, betaOrig := alpha, beta
     if len(fns) == 0 {
         var val float64
         if maxNotMin {
@@ -95,6 +172,15 @@ func SearchDeepAlphaBeta(stats *Stats, history []*Board, me int, depth int, alph
         }
         return nil, nil, true, val
     }
+    // Try the best move from the previous iteration first
+    if entry != nil {
+        for i, fn := range fns {
+            if fn().Key() == entry.best {
+                fns[0], fns[i] = fns[i], fns[0]
+                break
+            }
+        }
+    }
     var v float64
     var resBoard *Board
     var resFn func()*Board
@@ -109,10 +195,14 @@ func SearchDeepAlphaBeta(stats *Stats, history []*Board, me int, depth int, alph
             return next, fn, true, next.Eval(stats, me)
         }
         nHist := AddToHistory(history, next)
-        n, _, fin, val := SearchDeepAlphaBeta(stats, nHist, me, depth-1, alpha, beta, !maxNotMin, startTime, timeMillis) 
+        n, _, fin, val := SearchDeepAlphaBeta(stats, nHist, me, depth-1, alpha, beta, startTime, timeMillis, tt) 
         if !fin {
             return nil, nil, false, 0
         }
+        if resFn == nil {
+            resBoard = n
+            resFn = fn
+        }
         if maxNotMin {
             if val > v {
                 v = val
@@ -121,7 +211,7 @@ func SearchDeepAlphaBeta(stats *Stats, history []*Board, me int, depth int, alph
                 alpha = max(alpha, v)
             }
             if v >= beta {
-                return resBoard, resFn, true, v
+                break
             }
         } else {
             if val < v {
@@ -131,10 +221,17 @@ func SearchDeepAlphaBeta(stats *Stats, history []*Board, me int, depth int, alph
                 beta = min(beta, v)
             }
             if v <= alpha {
-                return resBoard, resFn, true, v
+                break
             }
         }
     }
+    flag := ttExact
+    if v <= alphaOrig {
+        flag = ttUpper
+    } else if v >= betaOrig {
+        flag = ttLower
+    }
+    tt[key] = &ttEntry{depth: depth, value: v, flag: flag, best: resFn().Key()}
     return resBoard, resFn, true, v
 }
 
@@ -2,6 +2,8 @@ package ai
 
 import (
     "fmt"
+    "math"
+    "math/rand"
     "testing"
 )
 
@@ -26,22 +28,42 @@ func TestMakeTraditional(t *testing.T) {
     }
 }
 
+func TestCheckGraph(t *testing.T) {
+    board := MakeTraditional(3, 2)
+    if err := CheckGraph(board.Points, board.Neighbors, 2); err != nil {
+        t.Errorf("expected a grid to pass got %v", err)
+    }
+    bad := [][][]int{{{1}, {}}, {{0, 1}, {0}}, {{2}, {0}}, {}}
+    for _, ns := range bad {
+        points := make([]int, len(ns))
+        if CheckGraph(points, ns, 2) == nil {
+            t.Errorf("expected error for %v", ns)
+        }
+    }
+    if CheckGraph([]int{-1, 2}, [][]int{{1}, {0}}, 2) == nil || CheckGraph([]int{-1}, [][]int{{1}, {0}}, 2) == nil {
+        t.Errorf("expected errors for a bad stone and a missing point")
+    }
+    if CheckGraph([]int{0, -1, 1}, [][]int{{1}, {0, 2}, {1}}, 2) != nil || CheckGraph([]int{0, 1}, [][]int{{1}, {0}}, 2) == nil {
+        t.Errorf("expected only the stone without liberties to fail")
+    }
+}
+
 func TestGetScores(t *testing.T) {
     board := MakeTraditional(3, 2)
-    board.Points[0] = 0
+    board.Set(0, 0)
     scores := board.GetScores()
     expect := []int{9, 0}
     if !Equals(expect, scores) {
         t.Errorf("expect %v got %v", expect, scores)
     }
-    board.Points[1] = 1
-    board.Points[3] = 1
+    board.Set(1, 1)
+    board.Set(3, 1)
     scores = board.GetScores()
     expect = []int{1, 8}
     if !Equals(expect, scores) {
         t.Errorf("expect %v got %v", expect, scores)
     }
-    board.Points[8] = 0
+    board.Set(8, 0)
     scores = board.GetScores()
     expect = []int{2, 2}
     if !Equals(expect, scores) {
@@ -56,19 +78,19 @@ func TestGetLiberties(t *testing.T) {
     if !Equals(expect, got) {
         t.Errorf("expect %v got %v", expect, got)
     }
-    board.Points[0] = 0
+    board.Set(0, 0)
     got = board.GetLiberties(0)
     expect = []int{2}
     if !Equals(expect, got) {
         t.Errorf("expect %v got %v", expect, got)
     }
-    board.Points[2] = 0
+    board.Set(2, 0)
     got = board.GetLiberties(0)
     expect = []int{2, 2}
     if !Equals(expect, got) {
         t.Errorf("expect %v got %v", expect, got)
     }
-    board.Points[1] = 1
+    board.Set(1, 1)
     got = board.GetLiberties(0)
     expect = []int{1, 1}
     if !Equals(expect, got) {
@@ -83,15 +105,15 @@ func TestGetLiberties(t *testing.T) {
 
 func TestCullCaptured(t *testing.T) {
     board := MakeTraditional(3, 2)
-    board.Points[0] = 0
+    board.Set(0, 0)
     board.CullCaptured(0)    
     board.CullCaptured(1)    
     expect := []int{0, -1, -1, -1, -1, -1, -1, -1, -1}
     if !Equals(expect, board.Points) {
         t.Errorf("expect %v got %v", expect, board.Points)
     }
-    board.Points[1] = 1
-    board.Points[3] = 1
+    board.Set(1, 1)
+    board.Set(3, 1)
     board.CullCaptured(1)    
     expect = []int{0, 1, -1, 1, -1, -1, -1, -1, -1}
     if !Equals(expect, board.Points) {
@@ -106,8 +128,8 @@ func TestCullCaptured(t *testing.T) {
 
 func TestGetStats(t *testing.T) {
     board := MakeTraditional(3, 2)
-    board.Points[0] = 0
-    board.Points[1] = 1
+    board.Set(0, 0)
+    board.Set(1, 1)
     stats := board.GetStats()
     if len(stats.Libs[0]) != 1 || len(stats.Libs[1]) != 1 {
         t.Errorf("bad lengths")
@@ -121,7 +143,7 @@ func TestGetStats(t *testing.T) {
     if !Equals([]float64{2, 0.75}, stats.LibDangers) {
         t.Errorf("expected %v got %v", []float64{2, 0.75}, stats.LibDangers)
     }
-    board.Points[6] = 0
+    board.Set(6, 0)
     stats = board.GetStats()
     if !Equals([]int{1, 2}, stats.Libs[0]) {
         t.Errorf("expected %v got %v", []int{1, 2}, stats.Libs[0])
@@ -145,12 +167,13 @@ func TestMoveOnce(t *testing.T) {
 
 func TestGetScoreContestedRegions(t *testing.T) {
     board := MakeTraditional(4, 2)
-    board.Points[0] = 0
-    board.Points[2] = 1
-    board.Points[4] = 1
-    board.Points[5] = 1
-    board.Points[6] = 0
-    s0, s1 := board.GetContestedScores()
+    board.Set(0, 0)
+    board.Set(2, 1)
+    board.Set(4, 1)
+    board.Set(5, 1)
+    board.Set(6, 0)
+    s := board.GetContestedScores()
+    s0, s1 := s[0], s[1]
     if s0 != 1 && s1 != 16 {
         t.Errorf("expect %v got %v", 1, s0)
         t.Errorf("expect %v got %v", 16, s1)
@@ -161,13 +184,14 @@ func TestGetScoreContestedRegions(t *testing.T) {
 
 func TestGetScoreContestedRegions2(t *testing.T) {
     board := MakeTraditional(4, 2)
-    board.Points[0] = 0
-    board.Points[2] = 1
-    board.Points[4] = 1
-    board.Points[5] = 1
-    board.Points[6] = 0
-    board.Points[10] = 0
-    s0, s1 := board.GetContestedScores()
+    board.Set(0, 0)
+    board.Set(2, 1)
+    board.Set(4, 1)
+    board.Set(5, 1)
+    board.Set(6, 0)
+    board.Set(10, 0)
+    s := board.GetContestedScores()
+    s0, s1 := s[0], s[1]
     if s0 != 3 && s1 != 5 {
         t.Errorf("expect %v got %v", 3, s0)
         t.Errorf("expect %v got %v", 5, s1)
@@ -175,3 +199,498 @@ func TestGetScoreContestedRegions2(t *testing.T) {
         t.Errorf("scores %v", scores)
     }
 }
+
+func TestSearchMCTS(t *testing.T) {
+    board := MakeTraditional(3, 2)
+    history := []*Board{board}
+    next := Search(history, 0, 5, 200, 15, MCTS)
+    if next == nil {
+        t.Fatalf("expected a move")
+    }
+    if next.Turn != 1 {
+        t.Errorf("expected turn 1 got %v", next.Turn)
+    }
+    stones := 0
+    for _, p := range next.Points {
+        if p == 0 {
+            stones += 1
+        } else if p != -1 {
+            t.Errorf("unexpected stone %v", p)
+        }
+    }
+    if stones > 1 {
+        t.Errorf("expected at most one stone got %v", stones)
+    }
+    if Search(history, 1, 5, 200, 15, MCTS) != nil {
+        t.Errorf("expected no move when not on turn")
+    }
+}
+
+func TestZobristHash(t *testing.T) {
+    board := MakeTraditional(3, 2)
+    if board.Hash != 0 {
+        t.Errorf("expected empty hash 0 got %v", board.Hash)
+    }
+    board.Set(1, 1)
+    board.Set(3, 1)
+    b := board.Clone()
+    b.Set(0, 0)
+    b.CullCaptured(0)
+    if b.Hash != board.Hash {
+        t.Errorf("expected hash %v after capture got %v", board.Hash, b.Hash)
+    }
+    b.Set(4, 0)
+    b2 := b.Clone()
+    b2.Rehash()
+    if b.Hash != b2.Hash {
+        t.Errorf("incremental hash %v rehash %v", b.Hash, b2.Hash)
+    }
+    history := []*Board{board}
+    if InHistory(history, b) || !InHistory(history, board.Clone()) {
+        t.Errorf("bad history check")
+    }
+    // A collision is not a repeat
+    forged := b.Clone()
+    forged.Hash = board.Hash
+    if InHistory(history, forged) {
+        t.Errorf("expected a hash collision to be told apart")
+    }
+    empty := MakeTraditional(3, 2)
+    collide := empty.Clone()
+    collide.Turn += 1
+    next, _ := empty.Clone().Move([]*Board{empty}, 4, 0)
+    collide.Hash = next.Hash
+    if _, err := empty.Move([]*Board{collide, empty}, 4, 0); err != nil {
+        t.Errorf("expected a legal move despite a colliding hash got %v", err)
+    }
+    // A ko capture forbids the retake, so the same position
+    // set up from scratch searches under another key
+    ko := MakeTraditional(4, 2)
+    for _, p := range []int{1, 4, 9} {
+        ko.Set(p, 0)
+    }
+    for _, p := range []int{2, 5, 7, 10} {
+        ko.Set(p, 1)
+    }
+    taken, err := ko.Move([]*Board{ko}, 6, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    koHist := []*Board{ko, taken}
+    _, banned := taken.getCandidates(koHist, 1)
+    fresh := taken.Clone()
+    _, none := fresh.getCandidates([]*Board{fresh}, 1)
+    if !Equals([]int{5}, banned) || len(none) != 0 || historyKey(koHist, banned) == historyKey([]*Board{fresh}, none) {
+        t.Errorf("expected the ko to change the key got %v %v", banned, none)
+    }
+    // Player to move is part of the key
+    b2.Turn += 1
+    if b.Key() == b2.Key() {
+        t.Errorf("expected different keys for different turns")
+    }
+}
+
+func TestSearchAlphaBeta(t *testing.T) {
+    board := MakeTraditional(3, 2)
+    history := []*Board{board}
+    next := Search(history, 0, 4, 500, 15, AlphaBeta)
+    if next == nil {
+        t.Fatalf("expected a move")
+    }
+    if next.Turn != 1 {
+        t.Errorf("expected turn 1 got %v", next.Turn)
+    }
+}
+
+func TestPlay(t *testing.T) {
+    board := MakeTraditional(3, 2)
+    board.Play(1, 1)
+    board.Play(3, 1)
+    // Suicide removes the stone
+    if n := board.Play(0, 0); n != 1 || board.Points[0] != -1 {
+        t.Errorf("expected suicide got %v removed %v", board.Points, n)
+    }
+    board.Play(4, 0)
+    board.Play(2, 0)
+    if !Equals([]int{1, 2}, board.GetLiberties(0)) {
+        t.Errorf("expected %v got %v", []int{1, 2}, board.GetLiberties(0))
+    }
+    // Capture white at 1 by playing 0
+    if n := board.Play(0, 0); n != 1 {
+        t.Errorf("expected 1 capture got %v", n)
+    }
+    expect := []int{0, -1, 0, 1, 0, -1, -1, -1, -1}
+    if !Equals(expect, board.Points) {
+        t.Errorf("expect %v got %v", expect, board.Points)
+    }
+    // Incremental chains agree with a rebuild
+    libs := board.GetLiberties(0)
+    b := board.Clone()
+    b.chains = nil
+    if !Equals(libs, b.GetLiberties(0)) {
+        t.Errorf("incremental %v rebuilt %v", libs, b.GetLiberties(0))
+    }
+    if board.ChainLiberties(0) != 1 || board.ChainLiberties(4) != 3 {
+        t.Errorf("expected 1 and 3 liberties got %v %v", board.ChainLiberties(0), board.ChainLiberties(4))
+    }
+    // Chains are trusted after Clone and Play, check them over random games
+    rng := rand.New(rand.NewSource(1))
+    for game := 0; game < 20; game++ {
+        board := MakeTraditional(5, 2)
+        history := []*Board{board}
+        for i := 0; i < 60 && !board.GameOver(history); i++ {
+            next, err := board.Move(history, rng.Intn(len(board.Points)+1)-1, board.Turn % board.NPlayers)
+            if err != nil {
+                continue
+            }
+            if !next.chainsValid() {
+                t.Fatalf("chains out of sync after %v", history)
+            }
+            board = next
+            history = append(history, board)
+        }
+    }
+}
+
+func TestMove(t *testing.T) {
+    board := MakeTraditional(3, 2)
+    history := []*Board{board}
+    play := func(p int, me int, expect error) {
+        next, err := history[len(history)-1].Move(history, p, me)
+        if err != expect {
+            t.Errorf("move %v for %v expect %v got %v", p, me, expect, err)
+        }
+        if err == nil {
+            history = append(history, next)
+        }
+    }
+    play(1, 1, ErrNotYourTurn)
+    play(1, 0, nil)
+    play(1, 1, ErrOccupied)
+    play(9, 1, ErrNoSuchPoint)
+    play(3, 1, nil)
+    play(5, 0, nil)
+    play(2, 1, ErrSuicide)
+    play(-1, 1, nil)
+    play(-1, 0, nil)
+    play(4, 1, ErrGameOver)
+}
+
+func TestRules(t *testing.T) {
+    rules, err := ParseRules(`{"Ko": "simple", "Scoring": "territory", "Komi": 6.5, "Suicide": true}`)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := ParseRules(`{"Ko": "nope"}`); err == nil {
+        t.Errorf("expected error for unknown ko rule")
+    }
+    board := MakeTraditional(4, 2)
+    board.Rules = rules
+    for _, p := range []int{1, 4, 9} {
+        board.Play(p, 0)
+    }
+    for _, p := range []int{2, 5, 7, 10} {
+        board.Play(p, 1)
+    }
+    history := []*Board{board}
+    // Black takes the ko
+    next, err := board.Move(history, 6, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    history = append(history, next)
+    if next.Captures[0] != 1 {
+        t.Errorf("expected 1 capture got %v", next.Captures)
+    }
+    // White may not retake immediately
+    if _, err := next.Move(history, 5, 1); err != ErrKo {
+        t.Errorf("expected ko got %v", err)
+    }
+    // Suicide is allowed under these rules
+    suicide, err := next.Move(history, 0, 1)
+    if err != nil {
+        t.Errorf("expected suicide allowed got %v", err)
+    }
+    // It leaves the position as it was but is no pass
+    pass, _ := next.Move(history, -1, 1)
+    if LastMove(history, suicide) != 0 || LastMove(history, pass) != -1 {
+        t.Errorf("expected suicide at 0 and a pass got %v %v", LastMove(history, suicide), LastMove(history, pass))
+    }
+    // Territory: black has 0 and 5 plus a capture, white has 3 plus komi
+    scores := next.GetFinalScores()
+    if scores[0] != 3 || scores[1] != 7.5 {
+        t.Errorf("expected %v got %v", []float64{3, 7.5}, scores)
+    }
+}
+
+func TestThreePlayers(t *testing.T) {
+    board := MakeTraditional(4, 3)
+    board.Play(5, 0)
+    board.Play(1, 1)
+    board.Play(4, 2)
+    board.Turn = 3
+    if len(board.GetContestedScores()) != 3 || len(board.GetStats().LibDangers) != 3 {
+        t.Errorf("expected stats for three players")
+    }
+    history := []*Board{board}
+    for _, algo := range []Algorithm{AlphaBeta, MCTS} {
+        next := Search(history, 0, 4, 200, 15, algo)
+        if next == nil || next.Turn != 4 {
+            t.Errorf("%v: expected a move for player 0 got %v", algo, next)
+        }
+        if Search(history, 2, 4, 100, 15, algo) != nil {
+            t.Errorf("%v: expected no move for player 2", algo)
+        }
+    }
+    // Black at 5 is surrounded by white and red together
+    board.Play(6, 1)
+    board.Play(9, 2)
+    if board.Points[5] != -1 || board.Captures[2] != 1 {
+        t.Errorf("expected red to capture black at 5 got %v %v", board.Points, board.Captures)
+    }
+}
+
+func TestGetEyes(t *testing.T) {
+    board := MakeTraditional(4, 2)
+    // Two chains held together by the eye at 0 only
+    board.Set(1, 0)
+    board.Set(4, 0)
+    eyes := board.FindEyes(0)
+    if len(eyes) != 1 || eyes[0].True {
+        t.Errorf("expected one false eye")
+    }
+    if !Equals([]int{0, 0}, board.GetEyes(0)) {
+        t.Errorf("expected %v got %v", []int{0, 0}, board.GetEyes(0))
+    }
+    board.Set(5, 0)
+    if !Equals([]int{1}, board.GetEyes(0)) {
+        t.Errorf("expected %v got %v", []int{1}, board.GetEyes(0))
+    }
+}
+
+func TestLifeStatus(t *testing.T) {
+    board := MakeTraditional(5, 2)
+    // Black wall with three eye spaces on the left, white stone in the middle one
+    for _, p := range []int{2, 5, 6, 7, 12, 15, 16, 17, 22} {
+        board.Set(p, 0)
+    }
+    board.Set(11, 1)
+    status := board.GetLifeStatus()
+    if status[2] != Alive || status[22] != Alive {
+        t.Errorf("expected black alive got %v", status)
+    }
+    if status[11] != Dead {
+        t.Errorf("expected white dead got %v", status[11])
+    }
+    if !Equals([]int{25, 0}, board.GetScores()) {
+        t.Errorf("expected %v got %v", []int{25, 0}, board.GetScores())
+    }
+    board.Rules = &Rules{Scoring: TerritoryScoring, Ko: PositionalSuperko}
+    scores := board.GetFinalScores()
+    if !Equals([]float64{17, 0}, scores) {
+        t.Errorf("expected %v got %v", []float64{17, 0}, scores)
+    }
+    // Agreeing on the same stone by hand gives the same result
+    marked := board.RemoveStones(board.Chain(11))
+    if marked.Captures[0] != 1 || !Equals([]float64{17, 0}, marked.GetFinalScores()) {
+        t.Errorf("expected %v got %v", []float64{17, 0}, marked.GetFinalScores())
+    }
+}
+
+func TestSeki(t *testing.T) {
+    board := MakeTraditional(5, 2)
+    // Black on the left with an eye at 0, white on the right with an eye at 4
+    // sharing their last liberty at 21
+    for p := range board.Points {
+        if p % 5 < 2 {
+            board.Set(p, 0)
+        } else {
+            board.Set(p, 1)
+        }
+    }
+    board.Set(0, -1)
+    board.Set(4, -1)
+    board.Set(21, -1)
+    seki := board.GetSeki()
+    if len(seki.Stones) != 22 || !Equals([]int{21}, seki.Liberties) {
+        t.Errorf("expected seki got %v", seki)
+    }
+    counts := make([]int, 2)
+    for _, p := range seki.Stones {
+        counts[board.Points[p]] += 1
+    }
+    if !Equals([]int{8, 14}, counts) {
+        t.Errorf("expected %v got %v", []int{8, 14}, counts)
+    }
+    if !Equals([]int{9, 15}, board.GetScores()) {
+        t.Errorf("expected %v got %v", []int{9, 15}, board.GetScores())
+    }
+    board.Rules = &Rules{Scoring: TerritoryScoring, Ko: PositionalSuperko}
+    if !Equals([]float64{0, 0}, board.GetFinalScores()) {
+        t.Errorf("expected %v got %v", []float64{0, 0}, board.GetFinalScores())
+    }
+    // Black can now fill the shared liberty and capture
+    board.Set(4, 0)
+    if len(board.GetSeki().Stones) != 0 {
+        t.Errorf("expected no seki got %v", board.GetSeki())
+    }
+}
+
+func TestHandicap(t *testing.T) {
+    board := MakeTraditional(9, 2)
+    stones, err := board.HandicapPoints(5)
+    // Center then the 3-3 points
+    expect := []int{40, 20, 24, 56, 60}
+    if err != nil || !Equals(expect, stones) {
+        t.Errorf("expected %v got %v %v", expect, stones, err)
+    }
+    b, err := board.PlaceHandicap(0, 5)
+    if err != nil || b.Points[40] != 0 || board.Points[40] != -1 {
+        t.Errorf("expected handicap stone on 40 got %v", err)
+    }
+    if b.Turn % b.NPlayers != 1 {
+        t.Errorf("expected white to move got %d", b.Turn)
+    }
+    if _, err := board.HandicapPoints(MaxHandicap+1); err == nil {
+        t.Errorf("expected error for %d stones", MaxHandicap+1)
+    }
+}
+
+func TestLevels(t *testing.T) {
+    for _, name := range []string{"beginner", "expert", "mcts"} {
+        level, err := ParseLevel(name)
+        if err != nil || level.Name != name {
+            t.Errorf("expected level %s got %v %v", name, level, err)
+        }
+    }
+    if _, err := ParseLevel("grandmaster"); err == nil {
+        t.Errorf("expected error for unknown level")
+    }
+    // Always blunders
+    level := &Level{Name: "random", Blunder: 1}
+    rng := rand.New(rand.NewSource(1))
+    board := MakeTraditional(3, 2)
+    history := []*Board{board}
+    for i := 0; i < 6; i++ {
+        me := board.Turn % board.NPlayers
+        if level.Move(history, 1-me, rng) != nil {
+            t.Errorf("expected no move out of turn")
+        }
+        board = level.Move(history, me, rng)
+        history = append(history, board)
+    }
+    if len(history) != 7 {
+        t.Errorf("expected 7 positions got %d", len(history))
+    }
+}
+
+func TestWeights(t *testing.T) {
+    weights, err := ParseWeights(`{"Eyes": 2, "LibDangers": [3]}`)
+    if err != nil || weights.Eyes != 2 || weights.Score != DefaultWeights.Score || len(weights.LibDangers) != 1 {
+        t.Errorf("expected eyes changed and the rest default got %v %v", weights, err)
+    }
+    if len(DefaultWeights.LibDangers) != 4 {
+        t.Errorf("default dangers changed to %v", DefaultWeights.LibDangers)
+    }
+    board := MakeTraditional(3, 2)
+    board.Set(0, 0)
+    board.Set(1, 1)
+    board.Weights = weights
+    if stats := board.GetStats(); !Equals([]float64{3, 0}, stats.LibDangers) {
+        t.Errorf("expected %v got %v", []float64{3, 0}, stats.LibDangers)
+    }
+    // Nothing counts with every weight zero, and clones keep the weights
+    board.Weights = &Weights{}
+    stats := board.GetStats()
+    next, err := board.Move([]*Board{board}, 4, 0)
+    if err != nil || next.Weights != board.Weights || next.Eval(stats, 0) != 0 {
+        t.Errorf("expected zero eval with zero weights")
+    }
+}
+
+func TestArenaStats(t *testing.T) {
+    lo, hi := Wilson(0.5, 100, Z95)
+    if math.Abs(lo - 0.4038) > 0.001 || math.Abs(hi - 0.5962) > 0.001 {
+        t.Errorf("expected [0.404, 0.596] got [%v, %v]", lo, hi)
+    }
+    if lo, hi := Wilson(1, 10, Z95); hi != 1 || lo <= 0.5 || lo >= 1 {
+        t.Errorf("bad interval for a sweep [%v, %v]", lo, hi)
+    }
+    if elo := EloDiff(0.75); math.Abs(elo - 190.85) > 0.01 || EloDiff(0.5) != 0 || math.IsInf(EloDiff(1), 0) {
+        t.Errorf("bad elo %v", elo)
+    }
+    a, b := &Level{Name: "a"}, &Level{Name: "b"}
+    games := make([]ArenaGame, 0)
+    for i := 0; i < 6; i++ {
+        games = append(games, ArenaGame{Black: "a", White: "b", Winner: "a"}, ArenaGame{Black: "b", White: "a", Winner: "b"})
+    }
+    games = append(games, ArenaGame{Black: "a", White: "b", Winner: "a"})
+    ratings := FitElo([]*Level{a, b}, games)
+    // 7.5 to 6.5 with the extra draw
+    if ratings[0].Elo != 0 || math.Abs(ratings[1].Elo - 400*math.Log10(6.5/7.5)) > 0.01 {
+        t.Errorf("bad ratings %v", ratings)
+    }
+    pairs := Pairs([]*Level{a, b}, games)
+    if len(pairs) != 2 || pairs[0].Wins != 7 || pairs[0].Losses != 6 || pairs[1].Wins != 6 {
+        t.Errorf("bad pairs %v", pairs)
+    }
+}
+
+func TestRunArena(t *testing.T) {
+    fast, err := ParseEngine(`{"Name": "fast", "Depth": 2, "TimeMillis": 20, "NTop": 5}`)
+    if err != nil || fast.Algorithm != AlphaBeta || fast.Blunder != 0 {
+        t.Fatalf("bad engine %v %v", fast, err)
+    }
+    eyes, err := ParseEngine(`{"Name": "eyes", "Base": "beginner", "Weights": {"Eyes": 3}}`)
+    if err != nil || eyes.Depth != 2 || eyes.Weights.Eyes != 3 {
+        t.Fatalf("bad engine %v %v", eyes, err)
+    }
+    if _, err := ParseEngine(`{"Depth": 2}`); err == nil {
+        t.Errorf("expected an error without a name")
+    }
+    boards := []ArenaBoard{{Name: "3x3", Board: MakeTraditional(3, 2)}, {Name: "4x4", Board: MakeTraditional(4, 2)}}
+    res := RunArena([]*Level{fast, eyes}, boards, 2, 3, 1, nil)
+    if len(res.Games) != 4 || len(res.Pairs) != 2 || res.Pairs[0].Games != 4 {
+        t.Fatalf("expected 4 games got %v", res.Games)
+    }
+    // Colors alternate on each board
+    for i := 0; i < 4; i += 2 {
+        g, h := res.Games[i], res.Games[i+1]
+        if g.Board != h.Board || g.Black != h.White || g.Black != "fast" {
+            t.Errorf("expected colors to alternate got %v %v", g, h)
+        }
+    }
+}
+
+// Middle game position from a seeded random game
+func benchHistory(n int, moves int) []*Board {
+    rng := rand.New(rand.NewSource(1))
+    board := MakeTraditional(n, 2)
+    history := []*Board{board}
+    for len(history) <= moves {
+        next, err := board.Move(history, rng.Intn(len(board.Points)), board.Turn % board.NPlayers)
+        if err != nil {
+            continue
+        }
+        board = next
+        history = append(history, board)
+    }
+    return history
+}
+
+func BenchmarkGetStats(b *testing.B) {
+    history := benchHistory(9, 30)
+    board := history[len(history)-1]
+    for i := 0; i < b.N; i++ {
+        board.GetStats()
+    }
+}
+
+// Fixed depth and no time limit, so the cost of a search is the cost of its leaves
+func BenchmarkSearch(b *testing.B) {
+    history := benchHistory(7, 16)
+    board := history[len(history)-1]
+    for i := 0; i < b.N; i++ {
+        Search(history, board.Turn % board.NPlayers, 3, 1000000, 0, AlphaBeta)
+    }
+}
@@ -0,0 +1,332 @@
+package ai
+
+import (
+    "encoding/json"
+    "errors"
+    "math"
+    "math/rand"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Arena for comparing engine settings, driven by ai/cli
+// Every pair of engines plays the same number of games on each board,
+// colors alternating, with the games spread over a number of goroutines
+// Draws count half a win. Scores come with a Wilson interval and an Elo
+// difference, and all engines get ratings from a Bradley-Terry fit
+// Thinking time is shared with the other games running at once,
+// so timed engines play weaker with more of them
+
+// Engine settings as given on the command line
+// Base is a preset the rest is laid over, medium by default
+type EngineSpec struct {
+    Name string
+    Base string
+    Algorithm string
+    Depth int
+    TimeMillis int
+    NTop int
+    Blunder float64
+    // Fields of Weights to change from the defaults
+    Weights json.RawMessage
+}
+
+// A preset name, or JSON of an EngineSpec
+func ParseEngine(spec string) (*Level, error) {
+    if !strings.HasPrefix(strings.TrimSpace(spec), "{") {
+        return ParseLevel(spec)
+    }
+    var es EngineSpec
+    err := json.Unmarshal([]byte(spec), &es)
+    if err != nil {
+        return nil, err
+    }
+    if es.Base == "" {
+        es.Base = "medium"
+    }
+    base, err := ParseLevel(es.Base)
+    if err != nil {
+        return nil, err
+    }
+    level := *base
+    if es.Name == "" {
+        return nil, errors.New("Engines need a Name")
+    }
+    level.Name = es.Name
+    if es.Algorithm != "" {
+        level.Algorithm, err = ParseAlgorithm(es.Algorithm)
+        if err != nil {
+            return nil, err
+        }
+    }
+    if es.Depth < 0 || es.TimeMillis < 0 || es.NTop < 0 || es.Blunder < 0 || es.Blunder > 1 {
+        return nil, errors.New("Bad settings for " + es.Name)
+    }
+    if es.Depth != 0 {
+        level.Depth = es.Depth
+    }
+    if es.TimeMillis != 0 {
+        level.TimeMillis = es.TimeMillis
+    }
+    if es.NTop != 0 {
+        level.NTop = es.NTop
+    }
+    if es.Blunder != 0 {
+        level.Blunder = es.Blunder
+    }
+    if es.Weights != nil {
+        level.Weights, err = ParseWeights(string(es.Weights))
+        if err != nil {
+            return nil, err
+        }
+    }
+    return &level, nil
+}
+
+type ArenaBoard struct {
+    Name string
+    Board *Board
+}
+
+type ArenaGame struct {
+    Index int
+    Board string
+    Black string
+    White string
+    // Engine that won, empty for a draw
+    Winner string
+    Moves int
+    BlackScore float64
+    WhiteScore float64
+    Seconds float64
+}
+
+// Results of one engine against another
+type PairResult struct {
+    Engine string
+    Opponent string
+    Games int
+    Wins int
+    Losses int
+    Draws int
+    // Fraction of the points won, draws count half
+    Score float64
+    // 95% Wilson interval of Score
+    Low float64
+    High float64
+    // Elo difference for Score and the interval
+    Elo float64
+    EloLow float64
+    EloHigh float64
+}
+
+type Rating struct {
+    Engine string
+    // Relative to the first engine
+    Elo float64
+}
+
+type ArenaResult struct {
+    Engines []*Level
+    Games []ArenaGame
+    Pairs []PairResult
+    Ratings []Rating
+}
+
+// 95% confidence
+const Z95 = 1.96
+
+// Wilson score interval for a fraction p of n games
+func Wilson(p float64, n int, z float64) (float64, float64) {
+    if n == 0 {
+        return 0, 1
+    }
+    nf := float64(n)
+    center := (p + z*z/(2*nf)) / (1 + z*z/nf)
+    half := z / (1 + z*z/nf) * math.Sqrt(p*(1-p)/nf + z*z/(4*nf*nf))
+    return math.Max(0, center - half), math.Min(1, center + half)
+}
+
+// Elo difference that gives an expected score p
+// Clamped so a clean sweep stays finite
+func EloDiff(p float64) float64 {
+    p = math.Max(0.001, math.Min(0.999, p))
+    return 400 * math.Log10(p / (1-p))
+}
+
+// Play games games between every pair of engines on each board
+// Progress, if not nil, is called after every game from the goroutine that played it
+func RunArena(engines []*Level, boards []ArenaBoard, games int, parallel int, seed int64, progress func(ArenaGame)) *ArenaResult {
+    type job struct {
+        index int
+        board ArenaBoard
+        black *Level
+        white *Level
+    }
+    jobs := make([]job, 0)
+    for i := 0; i < len(engines); i++ {
+        for j := i+1; j < len(engines); j++ {
+            for _, board := range boards {
+                for g := 0; g < games; g++ {
+                    black, white := engines[i], engines[j]
+                    if g % 2 == 1 {
+                        black, white = white, black
+                    }
+                    jobs = append(jobs, job{index: len(jobs), board: board, black: black, white: white})
+                }
+            }
+        }
+    }
+    if parallel < 1 {
+        parallel = 1
+    }
+    results := make([]ArenaGame, len(jobs))
+    ch := make(chan job)
+    var wg sync.WaitGroup
+    var mutex sync.Mutex
+    for w := 0; w < parallel; w++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for j := range ch {
+                // Searches cache chains on their boards, every game gets its own
+                rng := rand.New(rand.NewSource(seed + int64(j.index)))
+                start := time.Now()
+                history := SelfPlay(j.board.Board.Clone(), []*Level{j.black, j.white}, rng)
+                last := history[len(history)-1]
+                scores := last.GetFinalScores()
+                game := ArenaGame{
+                    Index: j.index,
+                    Board: j.board.Name,
+                    Black: j.black.Name,
+                    White: j.white.Name,
+                    Moves: len(history)-1,
+                    BlackScore: scores[0],
+                    WhiteScore: scores[1],
+                    Seconds: time.Since(start).Seconds(),
+                }
+                switch Winner(last) {
+                    case 0: game.Winner = j.black.Name
+                    case 1: game.Winner = j.white.Name
+                }
+                results[j.index] = game
+                if progress != nil {
+                    mutex.Lock()
+                    progress(game)
+                    mutex.Unlock()
+                }
+            }
+        }()
+    }
+    for _, j := range jobs {
+        ch <- j
+    }
+    close(ch)
+    wg.Wait()
+    res := &ArenaResult{Engines: engines, Games: results}
+    res.Pairs = Pairs(engines, results)
+    res.Ratings = FitElo(engines, results)
+    return res
+}
+
+// Results of every engine against every other one it played
+func Pairs(engines []*Level, games []ArenaGame) []PairResult {
+    pairs := make([]PairResult, 0)
+    for _, e := range engines {
+        for _, o := range engines {
+            if e == o {
+                continue
+            }
+            pair := PairResult{Engine: e.Name, Opponent: o.Name}
+            for _, game := range games {
+                if !(game.Black == e.Name && game.White == o.Name || game.Black == o.Name && game.White == e.Name) {
+                    continue
+                }
+                pair.Games += 1
+                switch game.Winner {
+                    case e.Name: pair.Wins += 1
+                    case o.Name: pair.Losses += 1
+                    default: pair.Draws += 1
+                }
+            }
+            if pair.Games == 0 {
+                continue
+            }
+            pair.Score = (float64(pair.Wins) + 0.5*float64(pair.Draws)) / float64(pair.Games)
+            pair.Low, pair.High = Wilson(pair.Score, pair.Games, Z95)
+            pair.Elo, pair.EloLow, pair.EloHigh = EloDiff(pair.Score), EloDiff(pair.Low), EloDiff(pair.High)
+            pairs = append(pairs, pair)
+        }
+    }
+    return pairs
+}
+
+// Ratings of the engines from all their games, the first one at 0
+// Bradley-Terry strengths by minorization-maximization, every pair
+// gets one extra draw so an engine that never wins still has a rating
+func FitElo(engines []*Level, games []ArenaGame) []Rating {
+    n := len(engines)
+    index := make(map[string]int)
+    for i, e := range engines {
+        index[e.Name] = i
+    }
+    // Points won and games played between each pair
+    won := make([][]float64, n)
+    played := make([][]float64, n)
+    for i := range won {
+        won[i] = make([]float64, n)
+        played[i] = make([]float64, n)
+    }
+    for _, game := range games {
+        b, w := index[game.Black], index[game.White]
+        played[b][w] += 1
+        played[w][b] += 1
+        switch game.Winner {
+            case game.Black: won[b][w] += 1
+            case game.White: won[w][b] += 1
+            default:
+                won[b][w] += 0.5
+                won[w][b] += 0.5
+        }
+    }
+    for i := 0; i < n; i++ {
+        for j := 0; j < n; j++ {
+            if i != j && played[i][j] > 0 {
+                won[i][j] += 0.5
+                played[i][j] += 1
+            }
+        }
+    }
+    strength := make([]float64, n)
+    for i := range strength {
+        strength[i] = 1
+    }
+    for iter := 0; iter < 1000; iter++ {
+        change := 0.0
+        for i := 0; i < n; i++ {
+            wins, denom := 0.0, 0.0
+            for j := 0; j < n; j++ {
+                if i == j || played[i][j] == 0 {
+                    continue
+                }
+                wins += won[i][j]
+                denom += played[i][j] / (strength[i] + strength[j])
+            }
+            if denom == 0 {
+                continue
+            }
+            next := wins / denom
+            change = math.Max(change, math.Abs(next - strength[i]) / strength[i])
+            strength[i] = next
+        }
+        if change < 1e-9 {
+            break
+        }
+    }
+    ratings := make([]Rating, n)
+    for i, e := range engines {
+        ratings[i] = Rating{Engine: e.Name, Elo: 400 * math.Log10(strength[i] / strength[0])}
+    }
+    return ratings
+}
@@ -0,0 +1,316 @@
+package main
+
+// Plays a GTP engine on a server as a bot, see bot.go for the protocol
+//   go run ./ai/bot -name mybot -- ./gtp-engine -level hard
+// Two player challenges are accepted one at a time, the engine is set up for
+// each game with boardsize on square grids and nongrid-loadgraph on other boards
+// Undo requests are declined and any scoring is accepted
+
+import (
+    "encoding/json"
+    "flag"
+    "log"
+    "os"
+    "os/exec"
+    "strconv"
+    "strings"
+
+    "github.com/gorilla/websocket"
+    ai "github.com/aorliche/web-nongrid-go/ai"
+    "github.com/aorliche/web-nongrid-go/clock"
+    "github.com/aorliche/web-nongrid-go/gtp"
+)
+
+// The fields of the server's requests a bot uses
+type Message struct {
+    Key int
+    Action string
+    Payload string
+    Player string
+    NPlayers int
+    Seat int
+    Clock string
+    Bot string
+}
+
+type BotGame struct {
+    Start []int
+    Neighbors [][]int
+    StartTurn int
+    Rules *ai.Rules
+    Moves []int
+}
+
+var colors = []string{"black", "white"}
+
+type Bridge struct {
+    ws *websocket.Conn
+    engine *gtp.Client
+    // Game being played, -1 between games
+    key int
+    seat int
+    size int
+    npts int
+    control *clock.Control
+    // Seat to move and passes in a row
+    turn int
+    passes int
+    // Our move was sent and not broadcast back yet
+    moving bool
+}
+
+func (bridge *Bridge) send(msg Message) {
+    err := bridge.ws.WriteJSON(msg)
+    if err != nil {
+        log.Fatal(err)
+    }
+}
+
+func (bridge *Bridge) command(format string, args ...any) string {
+    res, err := bridge.engine.Command(format, args...)
+    if err != nil {
+        log.Println(strings.Fields(format)[0], err)
+    }
+    return res
+}
+
+func seatOf(color string) int {
+    for i, c := range colors {
+        if c == strings.ToLower(color) {
+            return i
+        }
+    }
+    return -1
+}
+
+// Time settings in seconds
+// kgs-time_settings has byo-yomi, engines without it only get Canadian overtime
+// from time_settings, so byo-yomi goes over as one stone per period there
+// Neither has Fischer time, that goes over as one stone per increment,
+// the same time for each move after main time without saving any up
+func (bridge *Bridge) timeSettings() {
+    control := bridge.control
+    if control == nil {
+        return
+    }
+    main := control.Main/1000
+    kgs := bridge.command("known_command kgs-time_settings") == "true"
+    switch control.Kind {
+        case clock.Absolute:
+            if kgs {
+                bridge.command("kgs-time_settings absolute %d", main)
+            } else {
+                bridge.command("time_settings %d 0 0", main)
+            }
+        case clock.Canadian:
+            if kgs {
+                bridge.command("kgs-time_settings canadian %d %d %d", main, control.Period/1000, control.Stones)
+            } else {
+                bridge.command("time_settings %d %d %d", main, control.Period/1000, control.Stones)
+            }
+        case clock.ByoYomi:
+            if kgs {
+                bridge.command("kgs-time_settings byoyomi %d %d %d", main, control.Period/1000, control.Periods)
+            } else {
+                log.Println("byo-yomi sent as Canadian overtime, one stone per period and no extra periods")
+                bridge.command("time_settings %d %d 1", main, control.Period/1000)
+            }
+        case clock.Fischer:
+            log.Println("Fischer time sent as Canadian overtime, one stone per increment")
+            bridge.command("time_settings %d %d 1", main, control.Increment/1000)
+    }
+}
+
+// Load the board and the moves so far into the engine
+func (bridge *Bridge) setup(msg Message) {
+    var bg BotGame
+    err := json.Unmarshal([]byte(msg.Payload), &bg)
+    if err != nil {
+        log.Println(err)
+        return
+    }
+    bridge.key = msg.Key
+    bridge.seat = msg.Seat
+    bridge.npts = len(bg.Neighbors)
+    bridge.size = gtp.GridSize(bg.Neighbors)
+    if bridge.size != 0 {
+        bridge.command("boardsize %d", bridge.size)
+        bridge.command("clear_board")
+        for p, player := range bg.Start {
+            if player != -1 {
+                bridge.command("play %s %s", colors[player], gtp.Vertex(p, bridge.size))
+            }
+        }
+    } else {
+        points := bg.Start
+        if points == nil {
+            points = make([]int, bridge.npts)
+            for i := range points {
+                points[i] = -1
+            }
+        }
+        graph, _ := json.Marshal(map[string]any{"Points": points, "Neighbors": bg.Neighbors})
+        bridge.command("nongrid-loadgraph %s", graph)
+    }
+    if bg.Rules != nil {
+        bridge.command("komi %s", strconv.FormatFloat(bg.Rules.Komi, 'f', -1, 64))
+    }
+    bridge.timeSettings()
+    bridge.turn = bg.StartTurn
+    bridge.passes = 0
+    for _, p := range bg.Moves {
+        bridge.played(bridge.turn, p)
+    }
+    bridge.think()
+}
+
+// Tell the engine about a move from the server
+func (bridge *Bridge) played(seat int, p int) {
+    if seat != bridge.seat {
+        bridge.command("play %s %s", colors[seat], gtp.Vertex(p, bridge.size))
+    }
+    bridge.turn = (seat + 1) % len(colors)
+    if p == -1 {
+        bridge.passes += 1
+    } else {
+        bridge.passes = 0
+    }
+}
+
+// Move if it is our turn and the game goes on
+func (bridge *Bridge) think() {
+    if bridge.key == -1 || bridge.turn != bridge.seat || bridge.passes >= len(colors) || bridge.moving {
+        return
+    }
+    vertex := bridge.command("genmove %s", colors[bridge.seat])
+    if strings.ToLower(vertex) == "resign" {
+        bridge.send(Message{Action: "Concede", Key: bridge.key})
+        bridge.key = -1
+        return
+    }
+    p, err := gtp.ParseVertex(vertex, bridge.size, bridge.npts)
+    if err != nil {
+        log.Println("engine played", vertex, err)
+        p = -1
+    }
+    bridge.moving = true
+    bridge.send(Message{Action: "Move-AI", Key: bridge.key, Payload: `{"Point": ` + strconv.Itoa(p) + `}`})
+}
+
+func (bridge *Bridge) handle(msg Message) {
+    switch msg.Action {
+        case "Challenge":
+            action := "BotAccept"
+            if msg.NPlayers != len(colors) || bridge.key != -1 {
+                action = "BotDecline"
+            }
+            control, err := clock.ParseControl(msg.Clock)
+            if err != nil {
+                action = "BotDecline"
+            }
+            // A declined challenge leaves the game in progress alone
+            if action == "BotAccept" {
+                bridge.control = control
+            }
+            log.Println(action, "game", msg.Key)
+            bridge.send(Message{Action: action, Key: msg.Key})
+        case "BotGame":
+            bridge.setup(msg)
+        case "Move-AI":
+            if msg.Key != bridge.key {
+                return
+            }
+            var move struct {
+                Point int
+            }
+            json.Unmarshal([]byte(msg.Payload), &move)
+            seat := seatOf(msg.Player)
+            if seat == bridge.seat {
+                bridge.moving = false
+            }
+            bridge.played(seat, move.Point)
+            bridge.think()
+        case "Error":
+            log.Println("server:", msg.Payload)
+            // Our engine's move was refused, pass instead
+            if bridge.moving && msg.Key == bridge.key {
+                bridge.command("undo")
+                bridge.command("play %s pass", colors[bridge.seat])
+                // Only once, a pass that is refused too means the game is over
+                bridge.moving = false
+                bridge.send(Message{Action: "Move-AI", Key: bridge.key, Payload: `{"Point": -1}`})
+            }
+        case "UndoRequest":
+            if seatOf(msg.Player) != bridge.seat {
+                bridge.send(Message{Action: "UndoDecline", Key: bridge.key})
+            }
+        case "Score":
+            // Everyone gets Score again after each acceptance
+            var state struct {
+                Accepted []bool
+            }
+            json.Unmarshal([]byte(msg.Payload), &state)
+            if msg.Key == bridge.key && bridge.seat < len(state.Accepted) && !state.Accepted[bridge.seat] {
+                bridge.send(Message{Action: "AcceptScore", Key: bridge.key})
+            }
+        case "Result", "Concede", "Forfeit", "Timeout", "Abandoned", "Declined":
+            if msg.Key == bridge.key {
+                log.Println("game", msg.Key, msg.Action, msg.Payload)
+                bridge.key = -1
+                bridge.moving = false
+            }
+    }
+}
+
+func main() {
+    server := flag.String("server", "ws://localhost:8001/ws", "websocket of the server")
+    name := flag.String("name", "", "bot name, the engine's name by default")
+    flag.Parse()
+    if flag.NArg() == 0 {
+        log.Fatal("usage: bot [-server url] [-name name] engine [args...]")
+    }
+    cmd := exec.Command(flag.Arg(0), flag.Args()[1:]...)
+    cmd.Stderr = os.Stderr
+    stdin, err := cmd.StdinPipe()
+    if err != nil {
+        log.Fatal(err)
+    }
+    stdout, err := cmd.StdoutPipe()
+    if err != nil {
+        log.Fatal(err)
+    }
+    err = cmd.Start()
+    if err != nil {
+        log.Fatal(err)
+    }
+    engine := gtp.NewClient(stdout, stdin)
+    if *name == "" {
+        *name, err = engine.Command("name")
+        if err != nil {
+            log.Fatal(err)
+        }
+    }
+    ws, _, err := websocket.DefaultDialer.Dial(*server, nil)
+    if err != nil {
+        log.Fatal(err)
+    }
+    defer ws.Close()
+    bridge := &Bridge{ws: ws, engine: engine, key: -1}
+    bridge.send(Message{Action: "BotRegister", Bot: *name})
+    registered := false
+    for {
+        var msg Message
+        err := ws.ReadJSON(&msg)
+        if err != nil {
+            log.Fatal(err)
+        }
+        if msg.Action == "BotRegister" {
+            log.Println("registered as", msg.Bot)
+            registered = true
+        }
+        if msg.Action == "Error" && !registered {
+            log.Fatal(msg.Payload)
+        }
+        bridge.handle(msg)
+    }
+}
@@ -0,0 +1,273 @@
+package ai
+
+import (
+    "math/bits"
+)
+
+// Incremental chain (group) and liberty tracking
+// Every stone points at the head stone of its chain and chains are kept
+// as circular linked lists, so merging relabels only the smaller chain
+// Liberties are bitsets kept at the head stone
+
+type libSet []uint64
+
+func newLibSet(n int) libSet {
+    return make(libSet, (n+63)/64)
+}
+
+func (ls libSet) add(p int) {
+    ls[p/64] |= 1 << uint(p%64)
+}
+
+func (ls libSet) remove(p int) {
+    ls[p/64] &^= 1 << uint(p%64)
+}
+
+func (ls libSet) has(p int) bool {
+    return ls[p/64] & (1 << uint(p%64)) != 0
+}
+
+func (ls libSet) count() int {
+    c := 0
+    for _, w := range ls {
+        c += bits.OnesCount64(w)
+    }
+    return c
+}
+
+func (ls libSet) empty() bool {
+    for _, w := range ls {
+        if w != 0 {
+            return false
+        }
+    }
+    return true
+}
+
+func (ls libSet) union(other libSet) {
+    for i := range ls {
+        ls[i] |= other[i]
+    }
+}
+
+// Kept up to date by Play and copied by Clone
+// Set and Rehash drop them to be rebuilt on the next use
+type chains struct {
+    head []int
+    next []int
+    size []int
+    libs []libSet
+}
+
+func (c *chains) clone() *chains {
+    n := len(c.head)
+    nc := &chains{
+        head: make([]int, n),
+        next: make([]int, n),
+        size: make([]int, n),
+        libs: make([]libSet, n),
+    }
+    copy(nc.head, c.head)
+    copy(nc.next, c.next)
+    copy(nc.size, c.size)
+    for p, ls := range c.libs {
+        if ls != nil {
+            nc.libs[p] = make(libSet, len(ls))
+            copy(nc.libs[p], ls)
+        }
+    }
+    return nc
+}
+
+// Build chains from scratch in linear time
+func (board *Board) buildChains() *chains {
+    n := len(board.Points)
+    c := &chains{
+        head: make([]int, n),
+        next: make([]int, n),
+        size: make([]int, n),
+        libs: make([]libSet, n),
+    }
+    for p := range c.head {
+        c.head[p] = -1
+    }
+    stack := make([]int, 0)
+    for p, player := range board.Points {
+        if player == -1 || c.head[p] != -1 {
+            continue
+        }
+        libs := newLibSet(n)
+        c.head[p] = p
+        c.next[p] = p
+        c.size[p] = 1
+        stack = append(stack, p)
+        for len(stack) > 0 {
+            q := stack[len(stack)-1]
+            stack = stack[:len(stack)-1]
+            for _, nb := range board.Neighbors[q] {
+                if board.Points[nb] == -1 {
+                    libs.add(nb)
+                } else if board.Points[nb] == player && c.head[nb] == -1 {
+                    c.head[nb] = p
+                    c.next[nb] = c.next[p]
+                    c.next[p] = nb
+                    c.size[p] += 1
+                    stack = append(stack, nb)
+                }
+            }
+        }
+        c.libs[p] = libs
+    }
+    return c
+}
+
+// Chains for the current position
+// Not checked against Points, which is O(n) per call, tests do that with chainsValid
+func (board *Board) getChains() *chains {
+    if board.chains == nil {
+        board.chains = board.buildChains()
+    }
+    return board.chains
+}
+
+// The cached chains agree with a rebuild from Points
+func (board *Board) chainsValid() bool {
+    if board.chains == nil {
+        return true
+    }
+    c, r := board.chains, board.buildChains()
+    for p, player := range board.Points {
+        if (player == -1) != (c.head[p] == -1) {
+            return false
+        }
+        if player == -1 {
+            continue
+        }
+        if c.size[c.head[p]] != r.size[r.head[p]] || !Equals(c.libs[c.head[p]], r.libs[r.head[p]]) {
+            return false
+        }
+    }
+    return true
+}
+
+func (board *Board) mergeChains(a int, b int) int {
+    c := board.chains
+    if c.size[a] < c.size[b] {
+        a, b = b, a
+    }
+    for s := b; ; {
+        c.head[s] = a
+        s = c.next[s]
+        if s == b {
+            break
+        }
+    }
+    c.next[a], c.next[b] = c.next[b], c.next[a]
+    c.size[a] += c.size[b]
+    c.libs[a].union(c.libs[b])
+    c.libs[b] = nil
+    return a
+}
+
+// Remove a chain and hand its points to the neighboring chains as liberties
+func (board *Board) removeChain(h int) int {
+    c := board.chains
+    stones := make([]int, 0, c.size[h])
+    for s := h; ; {
+        stones = append(stones, s)
+        s = c.next[s]
+        if s == h {
+            break
+        }
+    }
+    for _, s := range stones {
+        board.set(s, -1)
+        c.head[s] = -1
+    }
+    c.libs[h] = nil
+    for _, s := range stones {
+        for _, nb := range board.Neighbors[s] {
+            if board.Points[nb] != -1 {
+                c.libs[c.head[nb]].add(s)
+            }
+        }
+    }
+    return len(stones)
+}
+
+// Place a stone for me on the empty point p, remove opponent chains left without liberties
+// and then my own chain if it has none (suicide)
+// Returns the number of stones removed
+func (board *Board) Play(p int, me int) int {
+    c := board.getChains()
+    board.set(p, me)
+    c.head[p] = p
+    c.next[p] = p
+    c.size[p] = 1
+    c.libs[p] = newLibSet(len(board.Points))
+    h := p
+    for _, nb := range board.Neighbors[p] {
+        if board.Points[nb] == -1 {
+            c.libs[h].add(nb)
+            continue
+        }
+        c.libs[c.head[nb]].remove(p)
+        if board.Points[nb] == me && c.head[nb] != h {
+            h = board.mergeChains(h, c.head[nb])
+        }
+    }
+    // Find every captured chain before removing any
+    // so that captures are simultaneous with more than one opponent
+    dead := make([]int, 0)
+    for _, nb := range board.Neighbors[p] {
+        player := board.Points[nb]
+        if player != -1 && player != me && c.libs[c.head[nb]].empty() && !Includes(dead, c.head[nb]) {
+            dead = append(dead, c.head[nb])
+        }
+    }
+    if board.Captures == nil {
+        board.Captures = make([]int, board.NPlayers)
+    }
+    removed := 0
+    for _, d := range dead {
+        n := board.removeChain(d)
+        board.Captures[me] += n
+        removed += n
+    }
+    // Suicided stones count as prisoners for the next player
+    if c.libs[h].empty() {
+        n := board.removeChain(h)
+        board.Captures[(me+1) % board.NPlayers] += n
+        removed += n
+    }
+    return removed
+}
+
+// Number of liberties of the chain at p
+func (board *Board) ChainLiberties(p int) int {
+    c := board.getChains()
+    if c.head[p] == -1 {
+        return 0
+    }
+    return c.libs[c.head[p]].count()
+}
+
+// Playing at p would leave my chain without liberties and capture nothing
+func (board *Board) isSuicide(p int, me int) bool {
+    c := board.getChains()
+    for _, nb := range board.Neighbors[p] {
+        player := board.Points[nb]
+        if player == -1 {
+            return false
+        }
+        libs := c.libs[c.head[nb]]
+        // Capture, or connect to a chain with another liberty
+        if player != me && libs.count() == 1 {
+            return false
+        }
+        if player == me && libs.count() > 1 {
+            return false
+        }
+    }
+    return true
+}
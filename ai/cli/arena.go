@@ -0,0 +1,66 @@
+package main
+
+import (
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "log"
+    "os"
+    "strconv"
+    ai "github.com/aorliche/web-nongrid-go/ai"
+)
+
+func runArena(levels []*ai.Level, boards []ai.ArenaBoard, games int, parallel int, seed int64, csvPath string, jsonPath string) {
+    total := games * len(boards) * len(levels) * (len(levels)-1) / 2
+    done := 0
+    res := ai.RunArena(levels, boards, games, parallel, seed, func(game ai.ArenaGame) {
+        done += 1
+        winner := game.Winner
+        if winner == "" {
+            winner = "draw"
+        }
+        fmt.Fprintf(os.Stderr, "%d/%d %s: %s vs %s, %s in %d moves (%.1fs)\n", done, total, game.Board, game.Black, game.White, winner, game.Moves, game.Seconds)
+    })
+    for _, pair := range res.Pairs {
+        fmt.Printf("%s vs %s: %d-%d-%d, %.1f%% [%.1f%%, %.1f%%], Elo %+.0f [%+.0f, %+.0f]\n",
+            pair.Engine, pair.Opponent, pair.Wins, pair.Losses, pair.Draws,
+            100*pair.Score, 100*pair.Low, 100*pair.High, pair.Elo, pair.EloLow, pair.EloHigh)
+    }
+    for _, rating := range res.Ratings {
+        fmt.Printf("%s: %+.0f\n", rating.Engine, rating.Elo)
+    }
+    if csvPath != "" {
+        writeCSV(csvPath, res.Games)
+    }
+    if jsonPath != "" {
+        jsn, err := json.MarshalIndent(res, "", "  ")
+        if err != nil {
+            log.Fatal(err)
+        }
+        err = os.WriteFile(jsonPath, jsn, 0644)
+        if err != nil {
+            log.Fatal(err)
+        }
+    }
+}
+
+// One row per game
+func writeCSV(path string, games []ai.ArenaGame) {
+    f, err := os.Create(path)
+    if err != nil {
+        log.Fatal(err)
+    }
+    defer f.Close()
+    w := csv.NewWriter(f)
+    w.Write([]string{"game", "board", "black", "white", "winner", "moves", "black_score", "white_score", "seconds"})
+    format := func(x float64) string {
+        return strconv.FormatFloat(x, 'f', -1, 64)
+    }
+    for _, game := range games {
+        w.Write([]string{strconv.Itoa(game.Index), game.Board, game.Black, game.White, game.Winner, strconv.Itoa(game.Moves), format(game.BlackScore), format(game.WhiteScore), format(game.Seconds)})
+    }
+    w.Flush()
+    if err := w.Error(); err != nil {
+        log.Fatal(err)
+    }
+}
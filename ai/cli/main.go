@@ -1,30 +1,139 @@
 package main
 
+// Without -engine, watch one game between search loops, printing every board
+// With two or more -engine flags, run an arena between them, see ai/arena.go
+//   go run ./ai/cli -engine medium -engine '{"Name": "eyes", "Weights": {"Eyes": 1}}' -size 7 -games 20
+
 import (
+    "flag"
     "fmt"
+    "log"
+    "os"
+    "runtime"
+    "strconv"
+    "strings"
+    "time"
     ai "github.com/aorliche/web-nongrid-go/ai"
+    "github.com/aorliche/web-nongrid-go/tiling"
 )
 
+// Repeatable string flag
+type list []string
+
+func (l *list) String() string {
+    return strings.Join(*l, ", ")
+}
+
+func (l *list) Set(s string) error {
+    *l = append(*l, s)
+    return nil
+}
+
+// Saved board under boards/, "default" for the browser's default board
+func loadBoard(name string, nplay int) *ai.Board {
+    if name == "default" {
+        return tiling.Default().ToBoard(nplay)
+    }
+    jsn, err := os.ReadFile("boards/" + name)
+    if err != nil {
+        log.Fatal(err)
+    }
+    t, err := tiling.FromJson(string(jsn))
+    if err != nil {
+        log.Fatal(err)
+    }
+    return t.ToBoard(nplay)
+}
+
 func main() {
-    nplay := 2
-    board := ai.MakeTraditional(4, nplay)
+    algoName := flag.String("algo", "alphabeta", "search algorithm (alphabeta or mcts) for a single game")
+    players := flag.Int("players", 2, "number of players in a single game")
+    var boardNames, engines list
+    flag.Var(&boardNames, "board", "saved board under boards/, \"default\" for the browser's default board, repeat for more arena boards")
+    flag.Var(&engines, "engine", "arena engine, a preset or JSON settings with Name, Base, Algorithm, Depth, TimeMillis, NTop, Blunder and Weights")
+    sizes := flag.String("size", "", "comma separated grid sizes for the arena")
+    games := flag.Int("games", 10, "arena games per pair of engines on each board")
+    parallel := flag.Int("parallel", runtime.NumCPU(), "arena games played at once")
+    komi := flag.Float64("komi", 0, "komi for white in the arena")
+    seed := flag.Int64("seed", time.Now().UnixNano(), "random seed for the arena")
+    csvPath := flag.String("csv", "", "write every arena game to this CSV file")
+    jsonPath := flag.String("json", "", "write the arena results to this JSON file")
+    flag.Parse()
+    if len(engines) == 0 {
+        algo, err := ai.ParseAlgorithm(*algoName)
+        if err != nil {
+            log.Fatal(err)
+        }
+        board := ai.MakeTraditional(4, *players)
+        if len(boardNames) > 0 {
+            board = loadBoard(boardNames[0], *players)
+        }
+        showGame(board, algo)
+        return
+    }
+    levels := make([]*ai.Level, 0)
+    for _, spec := range engines {
+        level, err := ai.ParseEngine(spec)
+        if err != nil {
+            log.Fatal(err)
+        }
+        for _, other := range levels {
+            if other.Name == level.Name {
+                log.Fatal("Two engines called ", level.Name)
+            }
+        }
+        levels = append(levels, level)
+    }
+    if len(levels) < 2 {
+        log.Fatal("The arena needs at least two engines")
+    }
+    rules := ai.DefaultRules
+    rules.Komi = *komi
+    boards := make([]ai.ArenaBoard, 0)
+    for _, name := range boardNames {
+        boards = append(boards, ai.ArenaBoard{Name: name, Board: loadBoard(name, 2)})
+    }
+    if *sizes != "" {
+        for _, s := range strings.Split(*sizes, ",") {
+            n, err := strconv.Atoi(strings.TrimSpace(s))
+            if err != nil || n < 2 {
+                log.Fatal("Bad grid size ", s)
+            }
+            boards = append(boards, ai.ArenaBoard{Name: fmt.Sprintf("%dx%d", n, n), Board: ai.MakeTraditional(n, 2)})
+        }
+    }
+    if len(boards) == 0 {
+        boards = append(boards, ai.ArenaBoard{Name: "7x7", Board: ai.MakeTraditional(7, 2)})
+    }
+    for _, b := range boards {
+        b.Board.Rules = &rules
+        b.Board.Rehash()
+    }
+    runArena(levels, boards, *games, *parallel, *seed, *csvPath, *jsonPath)
+}
+
+// The search loops take turns until the game is over
+func showGame(board *ai.Board, algo ai.Algorithm) {
+    nplay := board.NPlayers
     history := []*ai.Board{board}
     recvChan := make(chan bool)
     sendChans := make([]chan bool, 0)
     for i := 0; i < nplay; i++ {
         sendChans = append(sendChans, make(chan bool))
-        go ai.Loop(i, &history, sendChans[i], recvChan, 10, 1000, 15)
+        go ai.Loop(i, &history, sendChans[i], recvChan, 10, 1000, 15, algo)
     }
     for {
         fmt.Println("A", board)
-        for i := 0; i < nplay; i++ {
-            sendChans[i] <- true
-        }
         if board.GameOver(history) {
+            for i := 0; i < nplay; i++ {
+                sendChans[i] <- false
+            }
             fmt.Println("D", board)
             fmt.Println(board.GetScores())
             break
         }
+        // Only wake the player to move, so two loops never answer in one round
+        sendChans[board.Turn % nplay] <- true
         val := <- recvChan
         board = history[len(history) - 1]
         fmt.Println("C", val, board)
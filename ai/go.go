@@ -1,10 +1,23 @@
 package ai
 
+import (
+    "errors"
+    "strconv"
+)
+
 type Board struct {
     Points []int
     Neighbors [][]int
     NPlayers int
     Turn int
+    Hash uint64
+    // Shared between clones, nil for DefaultRules
+    Rules *Rules
+    // Shared between clones, nil for DefaultWeights
+    Weights *Weights
+    // Stones captured by each player
+    Captures []int
+    chains *chains
 }
 
 func Includes[T comparable](s []T, e T) bool {
@@ -28,15 +41,44 @@ func Equals[T comparable](s1 []T, s2 []T) bool {
     return true
 }
 
+// Hashes are compared first, Equals guards against a collision
 func InHistory(history []*Board, board *Board) bool {
     for _, b := range history {
-        if board.Equals(b) {
+        if b.Hash == board.Hash && b.Equals(board) {
             return true
         }
     }
     return false
 }
 
+// Check a graph from outside (browsers, SGF, GTP) before building a Board on it
+// Neighbors must be in range, not the point itself and go both ways,
+// and every point is empty or holds a stone of one of the players
+// with at least one liberty for its chain
+func CheckGraph(points []int, neighbors [][]int, nPlayers int) error {
+    npts := len(neighbors)
+    if npts == 0 || len(points) != npts {
+        return errors.New("Points don't match the neighbors")
+    }
+    for p, ns := range neighbors {
+        if points[p] < -1 || points[p] >= nPlayers {
+            return errors.New("Bad stone on point " + strconv.Itoa(p))
+        }
+        for _, n := range ns {
+            if n < 0 || n >= npts || n == p || !Includes(neighbors[n], p) {
+                return errors.New("Bad neighbors of point " + strconv.Itoa(p))
+            }
+        }
+    }
+    board := &Board{Points: points, Neighbors: neighbors, NPlayers: nPlayers}
+    for p, player := range points {
+        if player != -1 && board.ChainLiberties(p) == 0 {
+            return errors.New("No liberties for the stone on point " + strconv.Itoa(p))
+        }
+    }
+    return nil
+}
+
 func MakeTraditional(n int, nPlayers int) *Board {
     rc2p := func(r, c int) int {
         return r * n + c
@@ -73,11 +115,25 @@ func MakeTraditional(n int, nPlayers int) *Board {
 func (board *Board) Clone() *Board {
     points := make([]int, len(board.Points))
     copy(points, board.Points)
+    var c *chains
+    if board.chains != nil {
+        c = board.chains.clone()
+    }
+    var captures []int
+    if board.Captures != nil {
+        captures = make([]int, len(board.Captures))
+        copy(captures, board.Captures)
+    }
     return &Board{
         Points: points,
         Neighbors: board.Neighbors,
         NPlayers: board.NPlayers,
         Turn: board.Turn,
+        Hash: board.Hash,
+        Rules: board.Rules,
+        Weights: board.Weights,
+        Captures: captures,
+        chains: c,
     }
 }
 
@@ -91,210 +147,166 @@ func (board *Board) Equals(board2 *Board) bool {
 }
 
 func (board *Board) CullCaptured(me int) {
-    visited := make(map[int]bool)
-    cull := func(p int) {
-        frontier := []int{p}
-        region := make(map[int]bool)
-        foundEmpty := false
-        for len(frontier) > 0 {
-            p := frontier[0]
-            frontier = frontier[1:]
-            ns := board.Neighbors[p]
-            for _, n := range ns {
-                if Includes(frontier, n) || region[n] {
-                    continue
-                }
-                if board.Points[n] == me || board.Points[n] == -1 {
-                    if board.Points[n] == -1 {
-                        foundEmpty = true
-                    } else {
-                        frontier = append(frontier, n)
-                    }
-                }
-            }
-            visited[p] = true
-            region[p] = true
-        }
-        if !foundEmpty {
-            for rp := range region {
-                board.Points[rp] = -1
-            }
+    c := board.getChains()
+    for p, player := range board.Points {
+        if player == me && c.head[p] == p && c.libs[p].empty() {
+            board.removeChain(p)
         }
     }
+}
+
+// One for each island
+func (board *Board) GetLiberties(me int) []int {
+    libs := make([]int, 0)
+    c := board.getChains()
+    seen := make([]bool, len(board.Points))
     for p, player := range board.Points {
-        if !visited[p] && player == me {
-            cull(p)
+        if player == me && !seen[c.head[p]] {
+            seen[c.head[p]] = true
+            libs = append(libs, c.libs[c.head[p]].count())
         }
     }
+    return libs
 }
 
-// One for each island
-func (board *Board) GetEyes(me int) []int {
-    return nil
+// Connected empty area and the distinct stones bordering it
+type Region struct {
+    Points []int
+    Borders []int
 }
 
-// One for each island
-func (board *Board) GetLiberties(me int) []int {
-    libs := make([]int, 0)
-    visited := make(map[int]bool)
-    expand := func(p int) int {
-        lib := 0
-        frontier := []int{p}
-        for len(frontier) > 0 {
-            p := frontier[0]
-            visited[p] = true
-            frontier = frontier[1:]
-            ns := board.Neighbors[p]
-            for _, n := range ns {
-                if Includes(frontier, n) || visited[n] {
-                    continue
-                }
-                if board.Points[n] == me {
-                    frontier = append(frontier, n)
-                } else if board.Points[n] == -1 {
-                    lib += 1
-                }
-            }
+// Owners of the stones bordering the region
+func (board *Board) regionPlayers(r *Region) []int {
+    players := make([]int, 0)
+    for _, n := range r.Borders {
+        if !Includes(players, board.Points[n]) {
+            players = append(players, board.Points[n])
         }
-        return lib
     }
+    return players
+}
+
+// Empty regions and the region index of each point (-1 for stones)
+func (board *Board) GetEmptyRegions() ([]*Region, []int) {
+    regionOf := make([]int, len(board.Points))
+    for p := range regionOf {
+        regionOf[p] = -1
+    }
+    // Last region a stone was counted as a border of
+    bordered := make([]int, len(board.Points))
+    for p := range bordered {
+        bordered[p] = -1
+    }
+    regions := make([]*Region, 0)
     for p, player := range board.Points {
-        if !visited[p] && player == me {
-            libs = append(libs, expand(p))
+        if player != -1 || regionOf[p] != -1 {
+            continue
         }
+        idx := len(regions)
+        r := &Region{Points: []int{p}, Borders: make([]int, 0)}
+        regionOf[p] = idx
+        for i := 0; i < len(r.Points); i++ {
+            for _, n := range board.Neighbors[r.Points[i]] {
+                if board.Points[n] == -1 {
+                    if regionOf[n] == -1 {
+                        regionOf[n] = idx
+                        r.Points = append(r.Points, n)
+                    }
+                } else if bordered[n] != idx {
+                    bordered[n] = idx
+                    r.Borders = append(r.Borders, n)
+                }
+            }
+        }
+        regions = append(regions, r)
     }
-    return libs
+    return regions, regionOf
 }
 
 // For player me
 // Parameters: 
 // 1. max total empty plus enemy count for a region to be considered potentially "owned" by me
 // 2. max number of enemy stones in the region above
-func (board *Board) GetContestedScores() (int,int) {
+// One score for each player
+func (board *Board) GetContestedScores() []int {
+    regions, _ := board.GetEmptyRegions()
+    return board.contestedScores(regions)
+}
+
+func (board *Board) contestedScores(regions []*Region) []int {
     maxThresh := 15
     minThresh := 1
     ratThresh := 1.6
-    visited := make(map[int]bool)
-    expand := func(p int) (int,int) {
-        frontier := []int{p}
-        region := make(map[int]bool)
-        pCount := 0
-        bCount := 0
-        wCount := 0
-        for len(frontier) > 0 {
-            pCount += 1
-            p := frontier[0]
-            frontier = frontier[1:]
-            ns := board.Neighbors[p]
-            for _, n := range ns {
-                player := board.Points[n]
-                if Includes(frontier, n) || region[n] {
-                    continue;
-                }
-                if player == -1 {
-                    frontier = append(frontier, n)
-                } else if player == 0 {
-                    bCount += 1
-                    region[n] = true
-                } else {
-                    wCount += 1
-                    region[n] = true
-                }
-            }
-            region[p] = true
-            visited[p] = true
+    scores := make([]int, board.NPlayers)
+    for _, player := range board.Points {
+        if player != -1 {
+            scores[player] += 1
         }
-        bScore := pCount+wCount
-        wScore := pCount+bCount
-        bRat := float64(bCount) / float64(wCount)
-        wRat := 1/bRat
-        if pCount > maxThresh || bCount <= wCount || wCount > minThresh || bRat < ratThresh {
-            bScore = 0
+    } 
+    counts := make([]int, board.NPlayers)
+    for _, r := range regions {
+        pCount := len(r.Points)
+        for i := range counts {
+            counts[i] = 0
         }
-        if pCount > maxThresh || bCount >= wCount || bCount > minThresh || wRat < ratThresh {
-            wScore = 0
+        for _, n := range r.Borders {
+            counts[board.Points[n]] += 1
         }
-        return bScore, wScore
-    }
-    bScore, wScore := 0, 0
-    for p, player := range board.Points {
-        if visited[p] {
-            continue
-        }
-        if player == 0 {
-            bScore += 1
-        } else if player == 1 {
-            wScore += 1
-        } else {
-            b, w := expand(p)
-            bScore += b
-            wScore += w
+        for me, mine := range counts {
+            others := len(r.Borders) - mine
+            rat := float64(mine) / float64(others)
+            if pCount > maxThresh || mine <= others || others > minThresh || rat < ratThresh {
+                continue
+            }
+            scores[me] += pCount+others
         }
-    } 
-    return bScore, wScore
+    }
+    return scores
 }
 
+// Unconditionally dead stones are taken off first
+// Stones in seki and their eyes count, the liberties they share do not
 func (board *Board) GetScores() []int {
-    visited := make(map[int]bool)
-    expandGetEmptyScore := func(p int) (bool, int, int) {
-        frontier := []int{p}
-        region := make(map[int]bool)
-        me := -1
-        contested := false
-        for len(frontier) > 0 {
-            p := frontier[0]
-            frontier = frontier[1:]
-            ns := board.Neighbors[p]
-            for _, n := range ns {
-                if Includes(frontier, n) || region[n] {
-                    continue;
-                }
-                player := board.Points[n]
-                if player != -1 {
-                    if me == -1 {
-                        me = player
-                    } else if me != player {
-                        contested = true
-                    }
-                } else {
-                    frontier = append(frontier, n)
-                }
-            }
-            visited[p] = true
-            region[p] = true
-        }
-        return contested, me, len(region)
-    }
+    board = board.RemoveDead()
+    regions, _ := board.GetEmptyRegions()
+    return board.areaScores(regions)
+}
+
+// Stones plus the empty regions bordered by one player only, nothing taken off
+func (board *Board) areaScores(regions []*Region) []int {
     scores := make([]int, board.NPlayers)
-    for p, player := range board.Points {
-        if visited[p] {
-            continue
-        }
-        if player == -1 {
-            contested, player, size := expandGetEmptyScore(p)
-            if !contested && player != -1 {
-                scores[player] += size
-            } 
-        } else {
+    for _, player := range board.Points {
+        if player != -1 {
             scores[player] += 1
         }
     } 
+    for _, r := range regions {
+        players := board.regionPlayers(r)
+        if len(players) == 1 {
+            scores[players[0]] += len(r.Points)
+        }
+    }
     return scores
 }
 
+// Computed at every leaf of a search, so dead stones and seki are left to final scoring
 type Stats struct {
+    // Area scores without dead stones taken off
     Scores []int
     CScores []int
     Stones []int
     Libs [][]int
     LibDangers []float64
+    // True eyes of each island
+    Eyes [][]int
 }
 
 func (board *Board) GetStats() *Stats {
     stats := &Stats{}
-    bScore, wScore := board.GetContestedScores()
-    stats.Scores = board.GetScores()
-    stats.CScores = []int{bScore, wScore}
+    // Shared by the scores and the eyes
+    regions, _ := board.GetEmptyRegions()
+    stats.Scores = board.areaScores(regions)
+    stats.CScores = board.contestedScores(regions)
     stats.Stones = make([]int, board.NPlayers)
     for i := 0; i < board.NPlayers; i++ {
         for _, p := range board.Points {
@@ -305,19 +317,18 @@ func (board *Board) GetStats() *Stats {
     }
     stats.Libs = make([][]int, 0)
     stats.LibDangers = make([]float64, 0)
+    dangers := board.GetWeights().LibDangers
     for i := 0; i < board.NPlayers; i++ {
         libs := board.GetLiberties(i)
         stats.Libs = append(stats.Libs, libs)
         danger := 0.0
         for _, lib := range libs {
-            switch lib {
-                case 1: danger += 2.0
-                case 2: danger += 0.75
-                case 3: danger += 0.25
-                case 4: danger += 0.1
+            if lib >= 1 && lib <= len(dangers) {
+                danger += dangers[lib-1]
             }
         }
         stats.LibDangers = append(stats.LibDangers, danger)
+        stats.Eyes = append(stats.Eyes, board.getEyes(i, regions))
     }
     return stats
 }
@@ -328,6 +339,7 @@ func (board *Board) GetStats() *Stats {
 // 4. Minimize your opponent's liberties
 // 5. Minimize number of islands
 // 6. Maximize opponent's number of islands
+// 7. Make two eyes and keep your opponent from making them
 func (board *Board) Eval(before *Stats, me int) float64 {
     sum := func (libs []int) float64 {
         s := 0
@@ -336,14 +348,51 @@ func (board *Board) Eval(before *Stats, me int) float64 {
         }
         return float64(s)
     }
+    // A third eye is worth nothing more
+    eyeSum := func (eyes []int) float64 {
+        s := 0
+        for _, e := range eyes {
+            if e > 2 {
+                e = 2
+            }
+            s += e
+        }
+        return float64(s)
+    }
+    // Averaged over opponents, so just the opponent with two players
+    others := func (f func(int) float64) float64 {
+        s := 0.0
+        for i := 0; i < board.NPlayers; i++ {
+            if i != me {
+                s += f(i)
+            }
+        }
+        return s / float64(board.NPlayers-1)
+    }
+    w := board.GetWeights()
     after := board.GetStats()
-    a := 0.3*float64(after.Scores[me] - before.Scores[me] + before.Scores[1-me] - after.Scores[1-me])
-    b := before.LibDangers[me] - after.LibDangers[me] + after.LibDangers[1-me] - before.LibDangers[1-me]
-    c := 1*float64(len(before.Libs[me]) - len(after.Libs[me]) + len(after.Libs[1-me]) - len(before.Libs[1-me]))
-    d := float64(after.Stones[me] - before.Stones[me] + before.Stones[1-me] - after.Stones[1-me])
-    e := 0.3*(sum(after.Libs[me]) - sum(before.Libs[me]) + sum(before.Libs[1-me]) - sum(after.Libs[1-me]))
-    f := 0.5*float64(after.CScores[me] - before.CScores[me] + before.CScores[1-me] - after.CScores[1-me])
-    return a+b+c+d+e+f
+    a := w.Score*(float64(after.Scores[me] - before.Scores[me]) + others(func(i int) float64 {
+        return float64(before.Scores[i] - after.Scores[i])
+    }))
+    b := w.Dangers*(before.LibDangers[me] - after.LibDangers[me] + others(func(i int) float64 {
+        return after.LibDangers[i] - before.LibDangers[i]
+    }))
+    c := w.Islands*(float64(len(before.Libs[me]) - len(after.Libs[me])) + others(func(i int) float64 {
+        return float64(len(after.Libs[i]) - len(before.Libs[i]))
+    }))
+    d := w.Stones*(float64(after.Stones[me] - before.Stones[me]) + others(func(i int) float64 {
+        return float64(before.Stones[i] - after.Stones[i])
+    }))
+    e := w.Libs*(sum(after.Libs[me]) - sum(before.Libs[me]) + others(func(i int) float64 {
+        return sum(before.Libs[i]) - sum(after.Libs[i])
+    }))
+    f := w.Contested*(float64(after.CScores[me] - before.CScores[me]) + others(func(i int) float64 {
+        return float64(before.CScores[i] - after.CScores[i])
+    }))
+    g := w.Eyes*(eyeSum(after.Eyes[me]) - eyeSum(before.Eyes[me]) + others(func(i int) float64 {
+        return eyeSum(before.Eyes[i]) - eyeSum(after.Eyes[i])
+    }))
+    return a+b+c+d+e+f+g
 }
 
 func (board *Board) GameOver(history []*Board) bool {
@@ -360,9 +409,16 @@ func (board *Board) GameOver(history []*Board) bool {
 }
 
 func (board *Board) GetCandidates(history []*Board, me int) []func() *Board {
+    cand, _ := board.getCandidates(history, me)
+    return cand
+}
+
+// Also returns the points the ko rule forbids, which depend on the history
+func (board *Board) getCandidates(history []*Board, me int) ([]func() *Board, []int) {
     cand := make([]func() *Board, 0)
+    banned := make([]int, 0)
     if board.Turn % board.NPlayers != me {
-        return cand
+        return cand, banned
     }
     b := board.Clone()
     b.Turn += 1
@@ -370,23 +426,109 @@ func (board *Board) GetCandidates(history []*Board, me int) []func() *Board {
     cand = append(cand, func() *Board {
         return b
     })
+    rules := board.GetRules()
+    seen := board.koHashes(history)
     for p, player := range board.Points {
         if player == -1 {
-            b := board.Clone()
-            b.Points[p] = me
-            // Order matters
-            b.CullCaptured(1-me)
-            b.CullCaptured(me)
-            if InHistory(history, b) {
+            if !rules.Suicide && board.isSuicide(p, me) {
                 continue
             }
+            b := board.Clone()
+            b.Play(p, me)
             b.Turn += 1
+            if board.repeats(seen, b) {
+                banned = append(banned, p)
+                continue
+            }
             cand = append(cand, func() *Board {
                 return b
             })
         }
     }
-    return cand
+    return cand, banned
+}
+
+var (
+    ErrGameOver = errors.New("The game is over")
+    ErrNotYourTurn = errors.New("Not your turn")
+    ErrNoSuchPoint = errors.New("No such point")
+    ErrOccupied = errors.New("Point is occupied")
+    ErrSuicide = errors.New("Suicide is not allowed")
+    ErrKo = errors.New("Move repeats an earlier position")
+)
+
+// Check and make a move for me, p == -1 to pass
+// Returns the next board for the history
+func (board *Board) Move(history []*Board, p int, me int) (*Board, error) {
+    if board.GameOver(history) {
+        return nil, ErrGameOver
+    }
+    if board.Turn % board.NPlayers != me {
+        return nil, ErrNotYourTurn
+    }
+    next := board.Clone()
+    next.Turn += 1
+    if p == -1 {
+        return next, nil
+    }
+    if p < 0 || p >= len(board.Points) {
+        return nil, ErrNoSuchPoint
+    }
+    if board.Points[p] != -1 {
+        return nil, ErrOccupied
+    }
+    if !board.GetRules().Suicide && board.isSuicide(p, me) {
+        return nil, ErrSuicide
+    }
+    next.Play(p, me)
+    if board.repeats(board.koHashes(history), next) {
+        return nil, ErrKo
+    }
+    return next, nil
+}
+
+// The point played to get from the last board of history to next, -1 for a pass
+// A suicide leaves no new stone behind, so then try every empty point
+// A lone stone's suicide leaves the position as it was and only shows in the
+// captures, any point that gives the same board and captures will do
+func LastMove(history []*Board, next *Board) int {
+    board := history[len(history)-1]
+    me := board.Turn % board.NPlayers
+    if next.Equals(board) && sameCaptures(board, next) {
+        return -1
+    }
+    for p, player := range next.Points {
+        if player == me && board.Points[p] == -1 {
+            return p
+        }
+    }
+    for p, player := range board.Points {
+        if player != -1 {
+            continue
+        }
+        b, err := board.Move(history, p, me)
+        if err == nil && b.Equals(next) && sameCaptures(b, next) {
+            return p
+        }
+    }
+    return -1
+}
+
+// Nil captures count as none
+func sameCaptures(b1 *Board, b2 *Board) bool {
+    for i := 0; i < b1.NPlayers; i++ {
+        c1, c2 := 0, 0
+        if i < len(b1.Captures) {
+            c1 = b1.Captures[i]
+        }
+        if i < len(b2.Captures) {
+            c2 = b2.Captures[i]
+        }
+        if c1 != c2 {
+            return false
+        }
+    }
+    return true
 }
 
 func AddToHistory(history []*Board, board *Board) []*Board {
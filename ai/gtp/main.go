@@ -0,0 +1,31 @@
+package main
+
+// Go Text Protocol engine on standard input and output, for GTP controllers
+// and test harnesses, see the gtp package for the commands
+
+import (
+    "flag"
+    "log"
+    "math/rand"
+    "os"
+    "time"
+    ai "github.com/aorliche/web-nongrid-go/ai"
+    "github.com/aorliche/web-nongrid-go/gtp"
+)
+
+func main() {
+    levelName := flag.String("level", "medium", "difficulty preset or search algorithm")
+    boards := flag.String("boards", "boards", "directory nongrid-loadboard reads plans from")
+    seed := flag.Int64("seed", time.Now().UnixNano(), "random seed")
+    flag.Parse()
+    level, err := ai.ParseLevel(*levelName)
+    if err != nil {
+        log.Fatal(err)
+    }
+    engine := gtp.NewEngine(level, rand.New(rand.NewSource(*seed)))
+    engine.BoardsDir = *boards
+    err = engine.Run(os.Stdin, os.Stdout)
+    if err != nil {
+        log.Fatal(err)
+    }
+}
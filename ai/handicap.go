@@ -0,0 +1,112 @@
+package ai
+
+import (
+    "errors"
+)
+
+// Handicap stones on any neighbor graph
+// There are no star points, so stones go where closeness centrality (cubed)
+// times the distance to the stones already placed is highest:
+// the first on the most central point, the rest spread out
+// but kept away from the edge, where closeness is low
+
+const MaxHandicap = 9
+
+// Distances from p to every point, -1 when unreachable
+func (board *Board) Distances(p int) []int {
+    dist := make([]int, len(board.Points))
+    for i := range dist {
+        dist[i] = -1
+    }
+    dist[p] = 0
+    queue := []int{p}
+    for i := 0; i < len(queue); i++ {
+        q := queue[i]
+        for _, n := range board.Neighbors[q] {
+            if dist[n] == -1 {
+                dist[n] = dist[q] + 1
+                queue = append(queue, n)
+            }
+        }
+    }
+    return dist
+}
+
+// Inverse of the mean distance to the points p can reach
+func closeness(dist []int) float64 {
+    sum, n := 0, 0
+    for _, d := range dist {
+        if d > 0 {
+            sum += d
+            n += 1
+        }
+    }
+    if sum == 0 {
+        return 0
+    }
+    return float64(n) / float64(sum)
+}
+
+// Empty points for n handicap stones
+func (board *Board) HandicapPoints(n int) ([]int, error) {
+    if n < 0 || n > MaxHandicap {
+        return nil, errors.New("Handicap must be 0 to 9 stones")
+    }
+    npts := len(board.Points)
+    dists := make([][]int, npts)
+    central := make([]float64, npts)
+    for p := range board.Points {
+        dists[p] = board.Distances(p)
+        central[p] = closeness(dists[p])
+    }
+    // Distance to the nearest stone placed so far
+    nearest := make([]int, npts)
+    for p := range nearest {
+        nearest[p] = -1
+    }
+    stones := make([]int, 0, n)
+    for len(stones) < n {
+        best := -1
+        bestScore := 0.0
+        for p, player := range board.Points {
+            if player != -1 || nearest[p] == 0 {
+                continue
+            }
+            // Cubed so the edge loses out to spreading on grids from 9x9 to 19x19
+            score := central[p] * central[p] * central[p]
+            if nearest[p] > 0 {
+                score *= float64(nearest[p])
+            } else if len(stones) > 0 {
+                // Not connected to the other stones
+                continue
+            }
+            if best == -1 || score > bestScore {
+                best, bestScore = p, score
+            }
+        }
+        if best == -1 {
+            return nil, errors.New("No room for the handicap")
+        }
+        stones = append(stones, best)
+        for p, d := range dists[best] {
+            if d != -1 && (nearest[p] == -1 || d < nearest[p]) {
+                nearest[p] = d
+            }
+        }
+    }
+    return stones, nil
+}
+
+// The board with n handicap stones for player, who then lets the next player move first
+func (board *Board) PlaceHandicap(player int, n int) (*Board, error) {
+    stones, err := board.HandicapPoints(n)
+    if err != nil || n == 0 {
+        return board, err
+    }
+    b := board.Clone()
+    for _, p := range stones {
+        b.Set(p, player)
+    }
+    b.Turn = player + 1
+    return b, nil
+}
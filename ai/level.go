@@ -0,0 +1,99 @@
+package ai
+
+import (
+    "errors"
+    "math/rand"
+)
+
+// Difficulty presets for AI seats
+// Weaker levels search less and sometimes blunder on purpose,
+// playing a random legal move that doesn't fill one of their own eyes
+// Each level should beat the one below it clearly more often than not
+// Check with the arena, which gives win rates with confidence intervals:
+//   go run ./ai/cli -engine beginner -engine easy -engine medium -engine hard -engine expert -size 7 -games 40
+// Searches stop on time, so results depend on the machine and a seed doesn't repeat them
+
+type Level struct {
+    Name string
+    Algorithm Algorithm
+    Depth int
+    TimeMillis int
+    NTop int
+    // Chance of a random move instead of searching
+    Blunder float64
+    // Eval's weights, nil for the defaults
+    Weights *Weights
+}
+
+// Search goes Depth-1 moves deep, so beginner and easy both look one move ahead
+// and only their blunder rates tell them apart, one ply is quick whatever the time
+var Levels = []*Level{
+    {Name: "beginner", Algorithm: AlphaBeta, Depth: 2, TimeMillis: 200, NTop: 10, Blunder: 0.4},
+    {Name: "easy", Algorithm: AlphaBeta, Depth: 2, TimeMillis: 500, NTop: 20, Blunder: 0.15},
+    {Name: "medium", Algorithm: AlphaBeta, Depth: 3, TimeMillis: 1000, NTop: 50},
+    {Name: "hard", Algorithm: AlphaBeta, Depth: 5, TimeMillis: 2000, NTop: 200},
+    {Name: "expert", Algorithm: AlphaBeta, Depth: 7, TimeMillis: 4000, NTop: 200},
+}
+
+// A preset by name
+// A bare algorithm name gives the settings AI seats used before there were presets
+func ParseLevel(name string) (*Level, error) {
+    for _, level := range Levels {
+        if level.Name == name {
+            return level, nil
+        }
+    }
+    algo, err := ParseAlgorithm(name)
+    if err != nil {
+        return nil, errors.New("Unknown level " + name)
+    }
+    return &Level{Name: algo.String(), Algorithm: algo, Depth: 5, TimeMillis: 2000, NTop: 200}, nil
+}
+
+// Next position for player me, a pass if nothing was found
+// Returns nil when it is not my turn
+func (level *Level) Move(history []*Board, me int, rng *rand.Rand) *Board {
+    board := history[len(history)-1]
+    if board.Turn % board.NPlayers != me {
+        return nil
+    }
+    // Search with my own weights, whoever moved last
+    if board.Weights != level.Weights {
+        board = board.Clone()
+        board.Weights = level.Weights
+        history = append(history[:len(history)-1:len(history)-1], board)
+    }
+    if level.Blunder > 0 && rng.Float64() < level.Blunder {
+        if next := RandomMove(history, me, rng); next != nil {
+            return next
+        }
+    }
+    next := Search(history, me, level.Depth, level.TimeMillis, level.NTop, level.Algorithm)
+    if next == nil {
+        // Out of time before finding anything
+        next = board.Clone()
+        next.Turn += 1
+    }
+    return next
+}
+
+// A random legal move that doesn't fill my own eye, nil if there is none
+func RandomMove(history []*Board, me int, rng *rand.Rand) *Board {
+    board := history[len(history)-1]
+    empty := make([]int, 0)
+    for p, player := range board.Points {
+        if player == -1 && !board.isEye(p, me) {
+            empty = append(empty, p)
+        }
+    }
+    rng.Shuffle(len(empty), func(i, j int) {
+        empty[i], empty[j] = empty[j], empty[i]
+    })
+    for _, p := range empty {
+        next, err := board.Move(history, p, me)
+        if err == nil {
+            return next
+        }
+    }
+    return nil
+}
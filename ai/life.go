@@ -0,0 +1,429 @@
+package ai
+
+// Eyes and life and death on arbitrary neighbor graphs
+// Tilings have no diagonals to look at, so an eye is called false when the
+// chains around it are held together by that eye alone: the opponent can
+// then fill the outside liberties of one chain and capture it, filling the eye
+
+// Larger areas are territory rather than eyes
+const maxEyeSize = 6
+
+type Eye struct {
+    Points []int
+    // Head stones of the chains around the eye
+    Chains []int
+    True bool
+}
+
+type Status int
+
+const (
+    Unsettled Status = iota
+    // Cannot be captured even if its owner never plays again
+    Alive
+    // Inside the area of an alive chain, can never make eyes
+    Dead
+    // Shares liberties nobody can fill without being captured
+    Seki
+)
+
+func (s Status) String() string {
+    switch s {
+        case Alive: return "alive"
+        case Dead: return "dead"
+        case Seki: return "seki"
+    }
+    return "unsettled"
+}
+
+// Small empty regions bordered only by my stones
+func (board *Board) FindEyes(me int) []*Eye {
+    regions, _ := board.GetEmptyRegions()
+    return board.findEyes(me, regions)
+}
+
+func (board *Board) findEyes(me int, regions []*Region) []*Eye {
+    c := board.getChains()
+    eyes := make([]*Eye, 0)
+    for _, r := range regions {
+        players := board.regionPlayers(r)
+        if len(r.Points) > maxEyeSize || len(players) != 1 || players[0] != me {
+            continue
+        }
+        eye := &Eye{Points: r.Points, Chains: make([]int, 0)}
+        for _, n := range r.Borders {
+            if !Includes(eye.Chains, c.head[n]) {
+                eye.Chains = append(eye.Chains, c.head[n])
+            }
+        }
+        eyes = append(eyes, eye)
+    }
+    for i := range eyes {
+        eyes[i].True = eyesConnect(eyes, i)
+    }
+    return eyes
+}
+
+// Chains around eyes[skip] are connected through the other eyes
+func eyesConnect(eyes []*Eye, skip int) bool {
+    if len(eyes[skip].Chains) == 1 {
+        return true
+    }
+    // Union-find over head stones
+    parent := make(map[int]int)
+    var find func(int) int
+    find = func(h int) int {
+        p, ok := parent[h]
+        if !ok || p == h {
+            return h
+        }
+        parent[h] = find(p)
+        return parent[h]
+    }
+    for i, eye := range eyes {
+        if i == skip {
+            continue
+        }
+        for _, h := range eye.Chains[1:] {
+            parent[find(h)] = find(eye.Chains[0])
+        }
+    }
+    root := find(eyes[skip].Chains[0])
+    for _, h := range eyes[skip].Chains[1:] {
+        if find(h) != root {
+            return false
+        }
+    }
+    return true
+}
+
+// One for each island, the number of true eyes it touches
+func (board *Board) GetEyes(me int) []int {
+    regions, _ := board.GetEmptyRegions()
+    return board.getEyes(me, regions)
+}
+
+func (board *Board) getEyes(me int, regions []*Region) []int {
+    c := board.getChains()
+    counts := make([]int, len(board.Points))
+    for _, eye := range board.findEyes(me, regions) {
+        if !eye.True {
+            continue
+        }
+        for _, h := range eye.Chains {
+            counts[h] += 1
+        }
+    }
+    eyes := make([]int, 0)
+    seen := make([]bool, len(board.Points))
+    for p, player := range board.Points {
+        if player == me && !seen[c.head[p]] {
+            seen[c.head[p]] = true
+            eyes = append(eyes, counts[c.head[p]])
+        }
+    }
+    return eyes
+}
+
+// Benson's algorithm for player me
+// Returns which points hold my unconditionally alive stones
+// and which hold other players' stones inside the regions that keep them alive
+func (board *Board) benson(me int) ([]bool, []bool) {
+    c := board.getChains()
+    n := len(board.Points)
+    alivePts := make([]bool, n)
+    deadPts := make([]bool, n)
+    // Connected regions of points that are not mine
+    type region struct {
+        points []int
+        chains []int
+        // Chains every empty point of the region is a liberty of
+        vital []int
+    }
+    regions := make([]*region, 0)
+    inRegion := make([]bool, n)
+    for p, player := range board.Points {
+        if player == me || inRegion[p] {
+            continue
+        }
+        r := &region{points: []int{p}, chains: make([]int, 0)}
+        inRegion[p] = true
+        for i := 0; i < len(r.points); i++ {
+            for _, nb := range board.Neighbors[r.points[i]] {
+                if board.Points[nb] == me {
+                    if !Includes(r.chains, c.head[nb]) {
+                        r.chains = append(r.chains, c.head[nb])
+                    }
+                } else if !inRegion[nb] {
+                    inRegion[nb] = true
+                    r.points = append(r.points, nb)
+                }
+            }
+        }
+        for _, h := range r.chains {
+            vital := false
+            for _, q := range r.points {
+                if board.Points[q] != -1 {
+                    continue
+                }
+                if !c.libs[h].has(q) {
+                    vital = false
+                    break
+                }
+                vital = true
+            }
+            if vital {
+                r.vital = append(r.vital, h)
+            }
+        }
+        regions = append(regions, r)
+    }
+    alive := make([]bool, n)
+    for p, player := range board.Points {
+        if player == me {
+            alive[c.head[p]] = true
+        }
+    }
+    healthy := make([]bool, len(regions))
+    for i := range healthy {
+        healthy[i] = true
+    }
+    for {
+        changed := false
+        count := make([]int, n)
+        for i, r := range regions {
+            if healthy[i] {
+                for _, h := range r.vital {
+                    count[h] += 1
+                }
+            }
+        }
+        for h := range alive {
+            if alive[h] && count[h] < 2 {
+                alive[h] = false
+                changed = true
+            }
+        }
+        for i, r := range regions {
+            if !healthy[i] {
+                continue
+            }
+            for _, h := range r.chains {
+                if !alive[h] {
+                    healthy[i] = false
+                    changed = true
+                    break
+                }
+            }
+        }
+        if !changed {
+            break
+        }
+    }
+    for p, player := range board.Points {
+        if player == me && alive[c.head[p]] {
+            alivePts[p] = true
+        }
+    }
+    for i, r := range regions {
+        if !healthy[i] || len(r.vital) == 0 {
+            continue
+        }
+        for _, q := range r.points {
+            if board.Points[q] != -1 {
+                deadPts[q] = true
+            }
+        }
+    }
+    return alivePts, deadPts
+}
+
+// Status of the stone on each point, Unsettled for empty points
+// Alive and Dead are unconditional, Seki as found by GetSeki
+func (board *Board) GetLifeStatus() []Status {
+    status := board.bensonStatus()
+    for _, p := range board.getSeki(status).Stones {
+        status[p] = Seki
+    }
+    return status
+}
+
+func (board *Board) bensonStatus() []Status {
+    status := make([]Status, len(board.Points))
+    for me := 0; me < board.NPlayers; me++ {
+        alive, dead := board.benson(me)
+        for p := range status {
+            if alive[p] {
+                status[p] = Alive
+            } else if dead[p] && status[p] != Alive {
+                status[p] = Dead
+            }
+        }
+    }
+    return status
+}
+
+// The board with dead stones taken off and counted as prisoners
+// for the player whose area they were in
+// Returns the board itself if nothing is dead
+func (board *Board) RemoveDead() *Board {
+    alive := make([]bool, len(board.Points))
+    dead := make([][]bool, board.NPlayers)
+    for me := range dead {
+        var a []bool
+        a, dead[me] = board.benson(me)
+        for p := range a {
+            alive[p] = alive[p] || a[p]
+        }
+    }
+    var b *Board
+    for me, d := range dead {
+        for p := range d {
+            if !d[p] || alive[p] || b != nil && b.Points[p] == -1 {
+                continue
+            }
+            if b == nil {
+                b = board.Clone()
+                if b.Captures == nil {
+                    b.Captures = make([]int, b.NPlayers)
+                }
+            }
+            b.Set(p, -1)
+            b.Captures[me] += 1
+        }
+    }
+    if b == nil {
+        return board
+    }
+    return b
+}
+
+// Stones of the chain at p, empty for an empty point
+func (board *Board) Chain(p int) []int {
+    c := board.getChains()
+    stones := make([]int, 0)
+    if c.head[p] == -1 {
+        return stones
+    }
+    for s := c.head[p]; ; {
+        stones = append(stones, s)
+        s = c.next[s]
+        if s == c.head[p] {
+            break
+        }
+    }
+    return stones
+}
+
+// The board with the stones players agreed are dead taken off
+// Each counts as a prisoner for the only player around the area it ends up in
+func (board *Board) RemoveStones(stones []int) *Board {
+    b := board.Clone()
+    if b.Captures == nil {
+        b.Captures = make([]int, b.NPlayers)
+    }
+    for _, p := range stones {
+        b.Set(p, -1)
+    }
+    regions, regionOf := b.GetEmptyRegions()
+    for _, p := range stones {
+        players := b.regionPlayers(regions[regionOf[p]])
+        if len(players) == 1 {
+            b.Captures[players[0]] += 1
+        }
+    }
+    return b
+}
+
+// Chains in seki and the liberties they share
+type SekiInfo struct {
+    Stones []int
+    Liberties []int
+}
+
+// Playing at q leaves player with at least two liberties or captures something
+func (board *Board) safeMove(q int, player int) bool {
+    if board.isSuicide(q, player) {
+        return false
+    }
+    b := board.Clone()
+    if b.Play(q, player) > 0 {
+        return true
+    }
+    return b.ChainLiberties(q) >= 2
+}
+
+// Seki on any neighbor graph: unsettled chains of more than one player
+// around shared empty regions where no player can play safely,
+// with every other liberty of those chains in another such region or an eye of their own
+func (board *Board) GetSeki() *SekiInfo {
+    return board.getSeki(board.bensonStatus())
+}
+
+func (board *Board) getSeki(status []Status) *SekiInfo {
+    c := board.getChains()
+    regions, regionOf := board.GetEmptyRegions()
+    shared := make([]bool, len(regions))
+    for i, r := range regions {
+        // Shared liberties are few
+        if len(r.Points) > maxEyeSize || len(board.regionPlayers(r)) < 2 {
+            continue
+        }
+        shared[i] = true
+        for _, n := range r.Borders {
+            if status[n] != Unsettled {
+                shared[i] = false
+            }
+        }
+        for _, q := range r.Points {
+            for player := 0; player < board.NPlayers && shared[i]; player++ {
+                if board.safeMove(q, player) {
+                    shared[i] = false
+                }
+            }
+        }
+    }
+    // Chains with an outside liberty can still be captured
+    for changed := true; changed; {
+        changed = false
+        for i, r := range regions {
+            if !shared[i] {
+                continue
+            }
+            for _, n := range r.Borders {
+                libs := c.libs[c.head[n]]
+                for q := range board.Points {
+                    if !libs.has(q) || shared[regionOf[q]] {
+                        continue
+                    }
+                    players := board.regionPlayers(regions[regionOf[q]])
+                    if len(players) != 1 || players[0] != board.Points[n] {
+                        shared[i] = false
+                        changed = true
+                        break
+                    }
+                }
+                if !shared[i] {
+                    break
+                }
+            }
+        }
+    }
+    seki := &SekiInfo{Stones: make([]int, 0), Liberties: make([]int, 0)}
+    inSeki := make([]bool, len(board.Points))
+    for i, r := range regions {
+        if !shared[i] {
+            continue
+        }
+        seki.Liberties = append(seki.Liberties, r.Points...)
+        for _, n := range r.Borders {
+            if !inSeki[n] {
+                for _, s := range board.Chain(n) {
+                    inSeki[s] = true
+                    seki.Stones = append(seki.Stones, s)
+                }
+            }
+        }
+    }
+    return seki
+}
@@ -0,0 +1,199 @@
+package ai
+
+import (
+    "math"
+    "math/rand"
+    "time"
+)
+
+// Monte Carlo tree search with UCT selection
+// Playouts are random apart from never filling your own eyes
+// and are scored with GetFinalScores once every player has passed
+
+const uctExplore = 1.4
+
+type mctsNode struct {
+    board *Board
+    parent *mctsNode
+    children []*mctsNode
+    untried []func() *Board
+    expanded bool
+    terminal bool
+    visits int
+    wins float64
+}
+
+// Player who made the move leading to this node
+func (node *mctsNode) mover() int {
+    return (node.board.Turn + node.board.NPlayers - 1) % node.board.NPlayers
+}
+
+func (node *mctsNode) history(root []*Board) []*Board {
+    path := make([]*Board, 0)
+    for n := node; n.parent != nil; n = n.parent {
+        path = append(path, n.board)
+    }
+    hist := make([]*Board, len(root), len(root)+len(path))
+    copy(hist, root)
+    for i := len(path)-1; i >= 0; i-- {
+        hist = append(hist, path[i])
+    }
+    return hist
+}
+
+func (node *mctsNode) uct(child *mctsNode) float64 {
+    if child.visits == 0 {
+        return math.Inf(1)
+    }
+    exploit := child.wins / float64(child.visits)
+    explore := uctExplore * math.Sqrt(math.Log(float64(node.visits)) / float64(child.visits))
+    return exploit + explore
+}
+
+func (node *mctsNode) selectChild() *mctsNode {
+    var best *mctsNode
+    bestVal := math.Inf(-1)
+    for _, child := range node.children {
+        val := node.uct(child)
+        if best == nil || val > bestVal {
+            best = child
+            bestVal = val
+        }
+    }
+    return best
+}
+
+// A point whose neighbors all belong to me
+func (board *Board) isEye(p int, me int) bool {
+    for _, n := range board.Neighbors[p] {
+        if board.Points[n] != me {
+            return false
+        }
+    }
+    return len(board.Neighbors[p]) > 0
+}
+
+// Play a random move for whoever's turn it is
+// Returns false if the player had to pass
+func (board *Board) playoutMove(rng *rand.Rand) bool {
+    me := board.Turn % board.NPlayers
+    board.Turn += 1
+    empty := make([]int, 0)
+    for p, player := range board.Points {
+        if player == -1 && !board.isEye(p, me) {
+            empty = append(empty, p)
+        }
+    }
+    rng.Shuffle(len(empty), func(i, j int) {
+        empty[i], empty[j] = empty[j], empty[i]
+    })
+    for _, p := range empty {
+        // Don't commit suicide in playouts
+        if board.isSuicide(p, me) {
+            continue
+        }
+        board.Play(p, me)
+        return true
+    }
+    return false
+}
+
+// Play to the end of the game and return the reward for each player
+func (board *Board) playout(rng *rand.Rand) []float64 {
+    b := board.Clone()
+    passes := 0
+    maxMoves := 3*len(b.Points)
+    for i := 0; i < maxMoves && passes < b.NPlayers; i++ {
+        if b.playoutMove(rng) {
+            passes = 0
+        } else {
+            passes += 1
+        }
+    }
+    return b.rewards()
+}
+
+// Split a win between the players with the top score
+func (board *Board) rewards() []float64 {
+    scores := board.GetFinalScores()
+    best := math.Inf(-1)
+    for _, s := range scores {
+        if s > best {
+            best = s
+        }
+    }
+    winners := 0
+    for _, s := range scores {
+        if s == best {
+            winners += 1
+        }
+    }
+    rewards := make([]float64, len(scores))
+    for i, s := range scores {
+        if s == best {
+            rewards[i] = 1/float64(winners)
+        }
+    }
+    return rewards
+}
+
+func SearchMCTS(history []*Board, me int, timeMillis int) *Board {
+    startTime := time.Now()
+    rng := rand.New(rand.NewSource(startTime.UnixNano()))
+    root := &mctsNode{board: history[len(history)-1]}
+    root.untried = root.board.GetCandidates(history, me)
+    root.expanded = true
+    if len(root.untried) == 0 {
+        return nil
+    }
+    for time.Since(startTime).Milliseconds() < int64(timeMillis) {
+        // Selection
+        node := root
+        for len(node.untried) == 0 && len(node.children) > 0 {
+            node = node.selectChild()
+        }
+        // Expansion
+        if !node.expanded {
+            hist := node.history(history)
+            if node.board.GameOver(hist) {
+                node.terminal = true
+            } else {
+                node.untried = node.board.GetCandidates(hist, node.board.Turn % node.board.NPlayers)
+            }
+            node.expanded = true
+        }
+        if len(node.untried) > 0 {
+            i := rng.Intn(len(node.untried))
+            fn := node.untried[i]
+            node.untried[i] = node.untried[len(node.untried)-1]
+            node.untried = node.untried[:len(node.untried)-1]
+            child := &mctsNode{board: fn(), parent: node}
+            node.children = append(node.children, child)
+            node = child
+        }
+        // Simulation
+        var rewards []float64
+        if node.terminal {
+            rewards = node.board.rewards()
+        } else {
+            rewards = node.board.playout(rng)
+        }
+        // Backpropagation
+        for n := node; n != nil; n = n.parent {
+            n.visits += 1
+            if n.parent != nil {
+                n.wins += rewards[n.mover()]
+            }
+        }
+    }
+    var best *mctsNode
+    for _, child := range root.children {
+        if best == nil || child.visits > best.visits {
+            best = child
+        }
+    }
+    if best == nil {
+        return nil
+    }
+    return best.board
+}
@@ -0,0 +1,150 @@
+package ai
+
+import (
+    "encoding/json"
+    "errors"
+)
+
+type KoRule string
+
+const (
+    // Never repeat the previous position
+    SimpleKo KoRule = "simple"
+    // Never repeat any earlier position
+    PositionalSuperko KoRule = "positional"
+    // Never repeat an earlier position with the same player to move
+    SituationalSuperko KoRule = "situational"
+)
+
+type Scoring string
+
+const (
+    // Stones plus surrounded empty points
+    AreaScoring Scoring = "area"
+    // Surrounded empty points plus prisoners
+    TerritoryScoring Scoring = "territory"
+)
+
+type Rules struct {
+    Suicide bool
+    Ko KoRule
+    Scoring Scoring
+    // Added to every player but the first
+    Komi float64
+}
+
+var DefaultRules = Rules{
+    Suicide: false,
+    Ko: PositionalSuperko,
+    Scoring: AreaScoring,
+    Komi: 0,
+}
+
+// Empty string gives the default rules
+func ParseRules(jsn string) (*Rules, error) {
+    rules := DefaultRules
+    if jsn == "" {
+        return &rules, nil
+    }
+    err := json.Unmarshal([]byte(jsn), &rules)
+    if err != nil {
+        return nil, err
+    }
+    switch rules.Ko {
+        case "": rules.Ko = DefaultRules.Ko
+        case SimpleKo, PositionalSuperko, SituationalSuperko:
+        default: return nil, errors.New("Unknown ko rule " + string(rules.Ko))
+    }
+    switch rules.Scoring {
+        case "": rules.Scoring = DefaultRules.Scoring
+        case AreaScoring, TerritoryScoring:
+        default: return nil, errors.New("Unknown scoring " + string(rules.Scoring))
+    }
+    return &rules, nil
+}
+
+func (board *Board) GetRules() *Rules {
+    if board.Rules == nil {
+        return &DefaultRules
+    }
+    return board.Rules
+}
+
+// Positions a move may not recreate by hash
+// Situational superko hashes include the player to move
+func (board *Board) koHashes(history []*Board) map[uint64][]*Board {
+    switch board.GetRules().Ko {
+        case SimpleKo:
+            hashes := make(map[uint64][]*Board)
+            if len(history) >= 2 {
+                b := history[len(history)-2]
+                hashes[b.Hash] = []*Board{b}
+            }
+            return hashes
+        case SituationalSuperko:
+            hashes := make(map[uint64][]*Board, len(history))
+            for _, b := range history {
+                hashes[b.Key()] = append(hashes[b.Key()], b)
+            }
+            return hashes
+    }
+    return HistoryHashes(history)
+}
+
+// Check next (with its turn already advanced) against the ko rule
+// A matching hash is confirmed with Equals, so a collision never forbids a legal move
+func (board *Board) repeats(hashes map[uint64][]*Board, next *Board) bool {
+    key := next.Hash
+    situational := board.GetRules().Ko == SituationalSuperko
+    if situational {
+        key = next.Key()
+    }
+    for _, b := range hashes[key] {
+        if situational && b.Turn % b.NPlayers != next.Turn % next.NPlayers {
+            continue
+        }
+        if b.Equals(next) {
+            return true
+        }
+    }
+    return false
+}
+
+// Final score for each player under the rules, komi included
+// Unconditionally dead stones are taken off as prisoners
+// Territory scoring gives nothing for the eyes of groups in seki
+func (board *Board) GetFinalScores() []float64 {
+    rules := board.GetRules()
+    scores := make([]float64, board.NPlayers)
+    if rules.Scoring == TerritoryScoring {
+        board = board.RemoveDead()
+        inSeki := make([]bool, len(board.Points))
+        for _, p := range board.GetSeki().Stones {
+            inSeki[p] = true
+        }
+        regions, _ := board.GetEmptyRegions()
+        for _, r := range regions {
+            players := board.regionPlayers(r)
+            seki := false
+            for _, n := range r.Borders {
+                seki = seki || inSeki[n]
+            }
+            if len(players) == 1 && !seki {
+                scores[players[0]] += float64(len(r.Points))
+            }
+        }
+        for i := range scores {
+            if i < len(board.Captures) {
+                scores[i] += float64(board.Captures[i])
+            }
+        }
+    } else {
+        for i, s := range board.GetScores() {
+            scores[i] = float64(s)
+        }
+    }
+    for i := 1; i < board.NPlayers; i++ {
+        scores[i] += rules.Komi
+    }
+    return scores
+}
@@ -0,0 +1,35 @@
+package ai
+
+import (
+    "math/rand"
+)
+
+// Play a game out with one level on each seat, returns the history
+// Games that drag on past three moves per point are cut off there
+func SelfPlay(board *Board, levels []*Level, rng *rand.Rand) []*Board {
+    history := []*Board{board}
+    maxMoves := 3*len(board.Points)
+    for len(history) <= maxMoves && !board.GameOver(history) {
+        me := board.Turn % board.NPlayers
+        board = levels[me].Move(history, me, rng)
+        history = append(history, board)
+    }
+    return history
+}
+
+// Seat with the best final score, -1 for a tie
+func Winner(board *Board) int {
+    scores := board.GetFinalScores()
+    winner := 0
+    for i, score := range scores {
+        if score > scores[winner] {
+            winner = i
+        }
+    }
+    for i, score := range scores {
+        if i != winner && score == scores[winner] {
+            return -1
+        }
+    }
+    return winner
+}
@@ -0,0 +1,67 @@
+package ai
+
+import (
+    "encoding/json"
+    "errors"
+    "math"
+)
+
+// Weights of the terms of Eval, for tuning them with ai/cli
+// Shared between clones like Rules, so a search scores every position with the
+// weights of the board it started from
+
+type Weights struct {
+    // Points owned
+    Score float64
+    // Liberty danger of the islands, see LibDangers
+    Dangers float64
+    // Number of islands
+    Islands float64
+    Stones float64
+    // Liberties of all the islands
+    Libs float64
+    // Contested points
+    Contested float64
+    // Eyes, up to two per island
+    Eyes float64
+    // Danger of an island with 1, 2, 3 ... liberties, none past the end
+    LibDangers []float64
+}
+
+var DefaultWeights = Weights{
+    Score: 0.3,
+    Dangers: 1,
+    Islands: 1,
+    Stones: 1,
+    Libs: 0.3,
+    Contested: 0.5,
+    Eyes: 0.5,
+    LibDangers: []float64{2, 0.75, 0.25, 0.1},
+}
+
+// Empty string gives the default weights, missing fields keep their default
+func ParseWeights(jsn string) (*Weights, error) {
+    weights := DefaultWeights
+    weights.LibDangers = append([]float64{}, DefaultWeights.LibDangers...)
+    if jsn == "" {
+        return &weights, nil
+    }
+    err := json.Unmarshal([]byte(jsn), &weights)
+    if err != nil {
+        return nil, err
+    }
+    all := append([]float64{weights.Score, weights.Dangers, weights.Islands, weights.Stones, weights.Libs, weights.Contested, weights.Eyes}, weights.LibDangers...)
+    for _, w := range all {
+        if math.IsNaN(w) || math.IsInf(w, 0) {
+            return nil, errors.New("Weights must be finite")
+        }
+    }
+    return &weights, nil
+}
+
+func (board *Board) GetWeights() *Weights {
+    if board.Weights == nil {
+        return &DefaultWeights
+    }
+    return board.Weights
+}
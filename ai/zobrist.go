@@ -0,0 +1,99 @@
+package ai
+
+// Zobrist hashing
+// Keys are derived from the point index and player instead of a random
+// table, so boards built anywhere (browser, server, tests) agree on them
+// Empty points contribute nothing, so an empty board hashes to zero
+
+func splitmix64(x uint64) uint64 {
+    x += 0x9e3779b97f4a7c15
+    x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+    x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+    return x ^ (x >> 31)
+}
+
+func zobristKey(p int, player int) uint64 {
+    if player == -1 {
+        return 0
+    }
+    return splitmix64(uint64(p) << 8 | uint64(player))
+}
+
+func zobristTurnKey(player int) uint64 {
+    return splitmix64(^uint64(player))
+}
+
+// Set a point and keep the hash in sync
+// Prefer this to writing Points directly, it also drops the chains
+func (board *Board) Set(p int, player int) {
+    board.set(p, player)
+    board.chains = nil
+}
+
+// Set for Play, which updates the chains itself
+func (board *Board) set(p int, player int) {
+    board.Hash ^= zobristKey(p, board.Points[p]) ^ zobristKey(p, player)
+    board.Points[p] = player
+}
+
+// Recompute the hash and drop the chains after Points was written directly
+func (board *Board) Rehash() {
+    board.chains = nil
+    board.Hash = 0
+    for p, player := range board.Points {
+        board.Hash ^= zobristKey(p, player)
+    }
+}
+
+// Hash of the position plus the player to move
+func (board *Board) Key() uint64 {
+    return board.Hash ^ zobristTurnKey(board.Turn % board.NPlayers)
+}
+
+// Key of the position plus what the history adds to it: the passes that
+// end the history and the points the ko rule forbids
+// The same position reached another way may have other legal moves
+func historyKey(history []*Board, banned []int) uint64 {
+    board := history[len(history)-1]
+    key := board.Key()
+    passes := 0
+    for i := len(history)-2; i >= 0 && passes < board.NPlayers; i-- {
+        if history[i].Hash != board.Hash || !history[i].Equals(board) {
+            break
+        }
+        passes += 1
+    }
+    key ^= splitmix64(uint64(passes) << 32 | 0xba55)
+    for _, p := range banned {
+        key ^= splitmix64(uint64(p) << 32 | 0xc0)
+    }
+    return key
+}
+
+// Boards of history by hash, equal hashes may still be different positions
+func HistoryHashes(history []*Board) map[uint64][]*Board {
+    hashes := make(map[uint64][]*Board, len(history))
+    for _, b := range history {
+        hashes[b.Hash] = append(hashes[b.Hash], b)
+    }
+    return hashes
+}
+
+const (
+    ttExact = iota
+    ttLower
+    ttUpper
+)
+
+type ttEntry struct {
+    depth int
+    value float64
+    flag int
+    // Key of the best reply, tried first at the next depth
+    best uint64
+}
+
+// Keyed by Board.Key for leaves and historyKey for the rest
+// Values are relative to the stats and player of a single Search
+// so a table must not be shared between searches
+type TransTable map[uint64]*ttEntry
@@ -0,0 +1,270 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "sort"
+    "strings"
+    "sync"
+
+    "github.com/gorilla/websocket"
+    ai "github.com/aorliche/web-nongrid-go/ai"
+    "github.com/aorliche/web-nongrid-go/clock"
+)
+
+// Bot API
+// Outside engines play over /ws with the same messages as browsers, ai/bot
+// connects any GTP engine this way
+//   BotRegister  Bot names the bot, the answer is BotRegister or an Error if the name is taken
+//   Bots         anyone can list the registered bots, Payload is a JSON list of BotEntry
+//   Challenge    a human asks Bot for a game, with Rules, NPlayers, Board, BoardPlan
+//                or Payload and Clock as in New, and Player as their color
+//                The human gets New, the bot gets Challenge with the Key, its Seat,
+//                NPlayers, Rules, Clock and BoardPlan
+//   BotAccept, BotDecline  the bot's answer with the Key, a challenge that isn't
+//                accepted within AbandonTimeout is declined and everyone gets Declined
+// An accepted bot is seated like a human who joined, it gets Join with its Token
+// and then BotGame with the board as a graph in Payload
+// From then on it plays with Move-AI, every move is broadcast as Move-AI with
+// the point and the color that moved, and it answers UndoRequest, Score and so on
+// like anyone else, scoring waits for its AcceptScore
+// Bots play one game at a time, they can take a new challenge once it is over
+
+const MaxBotName = 40
+
+type Bot struct {
+    Name string
+    Conn *Conn
+    // Last game it was challenged to
+    game *Game
+}
+
+type BotEntry struct {
+    Name string
+    Busy bool
+}
+
+// Payload of BotGame
+type BotGame struct {
+    // Stones before the first move, -1 for empty
+    Start []int
+    Neighbors [][]int
+    StartTurn int
+    Rules *ai.Rules
+    // Point played each turn so far, -1 for a pass
+    Moves []int
+}
+
+// Registered bots by name
+// Games are locked inside the registry lock, never the other way around
+type BotRegistry struct {
+    mutex sync.Mutex
+    bots map[string]*Bot
+}
+
+var bots = &BotRegistry{bots: make(map[string]*Bot)}
+
+func (reg *BotRegistry) Register(name string, conn *Conn) (*Bot, error) {
+    name = strings.TrimSpace(name)
+    if name == "" || len(name) > MaxBotName {
+        return nil, errors.New("Bot names are 1 to 40 characters")
+    }
+    reg.mutex.Lock()
+    defer reg.mutex.Unlock()
+    if reg.bots[name] != nil {
+        return nil, errors.New("There is already a bot called " + name)
+    }
+    bot := &Bot{Name: name, Conn: conn}
+    reg.bots[name] = bot
+    return bot, nil
+}
+
+func (reg *BotRegistry) Remove(bot *Bot) {
+    reg.mutex.Lock()
+    defer reg.mutex.Unlock()
+    if reg.bots[bot.Name] == bot {
+        delete(reg.bots, bot.Name)
+    }
+}
+
+// Call with the registry locked
+func (bot *Bot) busy() bool {
+    if bot.game == nil {
+        return false
+    }
+    bot.game.Mutex.Lock()
+    defer bot.game.Mutex.Unlock()
+    return !bot.game.IsOver()
+}
+
+func (reg *BotRegistry) List() []BotEntry {
+    reg.mutex.Lock()
+    defer reg.mutex.Unlock()
+    list := make([]BotEntry, 0, len(reg.bots))
+    for _, bot := range reg.bots {
+        list = append(list, BotEntry{Name: bot.Name, Busy: bot.busy()})
+    }
+    sort.Slice(list, func(i, j int) bool {
+        return list[i].Name < list[j].Name
+    })
+    return list
+}
+
+// Hold the bot for a game, unless it is gone or playing another
+func (reg *BotRegistry) Reserve(name string, game *Game) (*Bot, error) {
+    reg.mutex.Lock()
+    defer reg.mutex.Unlock()
+    bot := reg.bots[name]
+    if bot == nil {
+        return nil, errors.New("No bot called " + name)
+    }
+    if bot.busy() {
+        return nil, errors.New(name + " is busy")
+    }
+    bot.game = game
+    return bot, nil
+}
+
+// Game the bot was last challenged to, nil for none
+func (reg *BotRegistry) Game(bot *Bot) *Game {
+    reg.mutex.Lock()
+    defer reg.mutex.Unlock()
+    return bot.game
+}
+
+// Seat the bot plays or was challenged to, -1 for none
+func (game *Game) BotSeat(name string) int {
+    for seat, bot := range game.Bots {
+        if bot == name {
+            return seat
+        }
+    }
+    return -1
+}
+
+func (game *Game) IsBot(seat int) bool {
+    return game.Bots != nil && game.Bots[seat] != ""
+}
+
+func (game *Game) HasBots() bool {
+    for seat := range game.Bots {
+        if game.IsBot(seat) {
+            return true
+        }
+    }
+    return false
+}
+
+// Every bot has accepted and taken its seat
+// Call with the game locked
+func (game *Game) BotsSeated() bool {
+    for seat, conn := range game.Conns {
+        if game.IsBot(seat) && conn == nil {
+            return false
+        }
+    }
+    return true
+}
+
+// Game for a challenge, the human at the seat of their color and the bot after them
+// Every other seat is open to humans
+func NewChallenge(req Request) (*Game, int, int, error) {
+    rules, err := ai.ParseRules(req.Rules)
+    if err != nil {
+        return nil, 0, 0, err
+    }
+    nPlayers, err := ParseNPlayers(req.NPlayers)
+    if err != nil {
+        return nil, 0, 0, err
+    }
+    seat, err := ParseColor(req.Player, nPlayers)
+    if err != nil {
+        return nil, 0, 0, err
+    }
+    if req.Board != "" {
+        req.BoardPlan, err = GetBoard(req.Board)
+        if err != nil {
+            return nil, 0, 0, errors.New("No board " + req.Board)
+        }
+    }
+    board, err := MakeBoard(req, nPlayers)
+    if err != nil {
+        return nil, 0, 0, errors.New("Bad board")
+    }
+    control, err := clock.ParseControl(req.Clock)
+    if err != nil {
+        return nil, 0, 0, err
+    }
+    board.Rules = rules
+    botSeat := (seat + 1) % nPlayers
+    game := &Game{
+        BoardPlan: req.BoardPlan,
+        Json: BoardToJson(board),
+        Conns: make([]*Conn, nPlayers),
+        Player: colors[0],
+        Players: make([]string, nPlayers),
+        Bots: make([]string, nPlayers),
+        Tokens: make([]string, nPlayers),
+        History: []*ai.Board{board},
+        State: Waiting,
+        Clocks: NewClocks(control, nPlayers),
+        UndoPolicy: UndoAlways,
+        Undos: make([]int, nPlayers),
+    }
+    game.Bots[botSeat] = req.Bot
+    // The bot's seat is held until it answers
+    game.Tokens[seat] = NewToken()
+    game.Tokens[botSeat] = NewToken()
+    return game, seat, botSeat, nil
+}
+
+// Invite the bot to the seat held for it
+// Call with the game locked
+func (game *Game) SendChallenge(bot *Bot, seat int) {
+    board := game.History[0]
+    rules, _ := json.Marshal(board.GetRules())
+    control := ""
+    if game.Clocks != nil {
+        jsn, _ := json.Marshal(game.Clocks[0].Control)
+        control = string(jsn)
+    }
+    reply := Request{Action: "Challenge", Key: game.Key, Seat: seat, NPlayers: board.NPlayers, Rules: string(rules), Clock: control, BoardPlan: game.BoardPlan, Bot: bot.Name}
+    jsn, _ := json.Marshal(reply)
+    bot.Conn.WriteMessage(websocket.TextMessage, jsn)
+}
+
+// The bot turned the challenge down, never answered or left
+// Call with the game locked
+func (game *Game) Decline(seat int) {
+    if game.State != Waiting || game.Conns[seat] != nil {
+        return
+    }
+    game.End(Abandoned, "Declined")
+    game.Save()
+    game.Broadcast(Request{Action: "Declined", Key: game.Key, Bot: game.Bots[seat]})
+}
+
+// Everything a bot needs to set up its engine
+// Call with the game locked
+func (game *Game) SendBotGame(conn *Conn, seat int) {
+    first := game.History[0]
+    bg := BotGame{
+        Neighbors: first.Neighbors,
+        StartTurn: first.Turn % first.NPlayers,
+        Rules: first.GetRules(),
+        Moves: make([]int, 0, len(game.History)-1),
+    }
+    for _, player := range first.Points {
+        if player != -1 {
+            bg.Start = first.Points
+            break
+        }
+    }
+    for i := 1; i < len(game.History); i++ {
+        bg.Moves = append(bg.Moves, ai.LastMove(game.History[:i], game.History[i]))
+    }
+    jsn, _ := json.Marshal(bg)
+    reply := Request{Action: "BotGame", Key: game.Key, Payload: string(jsn), Player: game.Player, NPlayers: first.NPlayers, Seat: seat, Token: game.Tokens[seat], Clocks: game.ClockState()}
+    jsn, _ = json.Marshal(reply)
+    conn.WriteMessage(websocket.TextMessage, jsn)
+}
@@ -0,0 +1,210 @@
+package clock
+
+import (
+    "encoding/json"
+    "errors"
+    "time"
+)
+
+// Time controls for one seat
+// Absolute: all moves in Main
+// Fischer: Increment is added after every move
+// Byo-yomi: after Main, Periods of Period each, a period is only used up
+// by a move that takes longer than it
+// Canadian: after Main, Stones moves in every Period
+// All times are in milliseconds so clocks go over the websocket as they are
+
+type Kind string
+
+const (
+    None Kind = ""
+    Absolute Kind = "absolute"
+    Fischer Kind = "fischer"
+    ByoYomi Kind = "byoyomi"
+    Canadian Kind = "canadian"
+)
+
+type Control struct {
+    Kind Kind
+    Main int
+    Increment int
+    Periods int
+    Period int
+    Stones int
+}
+
+// Parse a control sent by a client, nil for untimed games
+func ParseControl(jsn string) (*Control, error) {
+    if jsn == "" {
+        return nil, nil
+    }
+    var control Control
+    err := json.Unmarshal([]byte(jsn), &control)
+    if err != nil {
+        return nil, err
+    }
+    if control.Kind == None {
+        return nil, nil
+    }
+    if control.Main < 0 || control.Increment < 0 || control.Periods < 0 || control.Period < 0 || control.Stones < 0 {
+        return nil, errors.New("Negative time control")
+    }
+    switch control.Kind {
+        case Absolute:
+            if control.Main == 0 {
+                return nil, errors.New("Absolute time needs main time")
+            }
+        case Fischer:
+            // The increment only comes after a move, so the first one is on main time
+            if control.Main == 0 {
+                return nil, errors.New("Fischer time needs main time")
+            }
+        case ByoYomi:
+            if control.Periods == 0 || control.Period == 0 {
+                return nil, errors.New("Byo-yomi needs periods")
+            }
+        case Canadian:
+            if control.Stones == 0 || control.Period == 0 {
+                return nil, errors.New("Canadian overtime needs stones and a period")
+            }
+        default:
+            return nil, errors.New("Unknown time control " + string(control.Kind))
+    }
+    return &control, nil
+}
+
+// What is left on one seat's clock
+type Clock struct {
+    Control *Control
+    Main int
+    // Byo-yomi periods left
+    Periods int
+    // Time left in the current Canadian period
+    Period int
+    // Moves left in the current Canadian period
+    Stones int
+    Flagged bool
+}
+
+func (control *Control) NewClock() *Clock {
+    return &Clock{
+        Control: control,
+        Main: control.Main,
+        Periods: control.Periods,
+        Period: control.Period,
+        Stones: control.Stones,
+    }
+}
+
+// Time the player can think before losing
+func (clock *Clock) Remaining() time.Duration {
+    left := clock.Main
+    switch clock.Control.Kind {
+        case ByoYomi:
+            left += clock.Periods * clock.Control.Period
+        case Canadian:
+            if clock.Main > 0 {
+                left += clock.Control.Period
+            } else {
+                left = clock.Period
+            }
+    }
+    return time.Duration(left) * time.Millisecond
+}
+
+// Charge a move that took spent, returns true if the player ran out of time
+func (clock *Clock) Spend(spent time.Duration) bool {
+    ms := int(spent / time.Millisecond)
+    // Main time first
+    if ms <= clock.Main {
+        clock.Main -= ms
+        if clock.Control.Kind == Fischer {
+            clock.Main += clock.Control.Increment
+        }
+        return false
+    }
+    ms -= clock.Main
+    clock.Main = 0
+    switch clock.Control.Kind {
+        case ByoYomi:
+            // The period the move finished in is fresh again
+            for ms > clock.Control.Period && clock.Periods > 0 {
+                ms -= clock.Control.Period
+                clock.Periods -= 1
+            }
+            clock.Flagged = clock.Periods == 0
+        case Canadian:
+            clock.Period -= ms
+            if clock.Period < 0 {
+                clock.Flagged = true
+                break
+            }
+            clock.Stones -= 1
+            if clock.Stones == 0 {
+                clock.Stones = clock.Control.Stones
+                clock.Period = clock.Control.Period
+            }
+        default:
+            clock.Flagged = true
+    }
+    return clock.Flagged
+}
+
+// How long an engine should think on its next move
+func (clock *Clock) Budget() time.Duration {
+    ms := 0
+    switch clock.Control.Kind {
+        case Absolute:
+            ms = clock.Main/30
+        case Fischer:
+            ms = clock.Main/30 + clock.Control.Increment*4/5
+        case ByoYomi:
+            ms = clock.Main/30
+            if ms < clock.Control.Period*4/5 {
+                ms = clock.Control.Period*4/5
+            }
+        case Canadian:
+            if clock.Main > 0 {
+                ms = clock.Main/30
+            } else {
+                ms = clock.Period/clock.Stones*4/5
+            }
+    }
+    budget := time.Duration(ms) * time.Millisecond
+    // Searches need a moment to come up with anything at all
+    floor := clock.Remaining()/2
+    if floor > 100 * time.Millisecond {
+        floor = 100 * time.Millisecond
+    }
+    if budget < floor {
+        budget = floor
+    }
+    return budget
+}
+
+// The clock elapsed into the current turn, for showing players
+// Unlike Spend the move isn't finished, so nothing is given back
+func (clock *Clock) After(elapsed time.Duration) *Clock {
+    after := *clock
+    ms := int(elapsed / time.Millisecond)
+    if ms <= after.Main {
+        after.Main -= ms
+        return &after
+    }
+    ms -= after.Main
+    after.Main = 0
+    switch after.Control.Kind {
+        case ByoYomi:
+            for ms > after.Control.Period && after.Periods > 0 {
+                ms -= after.Control.Period
+                after.Periods -= 1
+            }
+            after.Flagged = after.Periods == 0
+        case Canadian:
+            after.Period -= ms
+            after.Flagged = after.Period < 0
+        default:
+            after.Flagged = true
+    }
+    return &after
+}
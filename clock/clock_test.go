@@ -0,0 +1,87 @@
+package clock
+
+import (
+    "testing"
+    "time"
+)
+
+func TestParseControl(t *testing.T) {
+    control, err := ParseControl("")
+    if control != nil || err != nil {
+        t.Errorf("expected untimed got %v %v", control, err)
+    }
+    control, err = ParseControl(`{"Kind": "fischer", "Main": 60000, "Increment": 5000}`)
+    if err != nil || control.Kind != Fischer || control.Increment != 5000 {
+        t.Errorf("expected fischer got %v %v", control, err)
+    }
+    for _, bad := range []string{`{"Kind": "sudden"}`, `{"Kind": "absolute"}`, `{"Kind": "byoyomi", "Main": 1000}`, `{"Kind": "absolute", "Main": -1}`, `{"Kind": "fischer", "Increment": 5000}`} {
+        if _, err := ParseControl(bad); err == nil {
+            t.Errorf("expected error for %s", bad)
+        }
+    }
+}
+
+func TestAbsoluteAndFischer(t *testing.T) {
+    clock := (&Control{Kind: Absolute, Main: 10000}).NewClock()
+    if clock.Spend(4 * time.Second) || clock.Main != 6000 {
+        t.Errorf("expected 6000 left got %v", clock)
+    }
+    if !clock.Spend(7 * time.Second) {
+        t.Errorf("expected flag got %v", clock)
+    }
+    clock = (&Control{Kind: Fischer, Main: 10000, Increment: 2000}).NewClock()
+    clock.Spend(4 * time.Second)
+    if clock.Main != 8000 || clock.Remaining() != 8 * time.Second {
+        t.Errorf("expected 8000 left got %v", clock)
+    }
+}
+
+func TestByoYomi(t *testing.T) {
+    clock := (&Control{Kind: ByoYomi, Main: 5000, Periods: 3, Period: 10000}).NewClock()
+    if clock.Remaining() != 35 * time.Second {
+        t.Errorf("expected 35s got %v", clock.Remaining())
+    }
+    // Into overtime, within the first period
+    if clock.Spend(12 * time.Second) || clock.Main != 0 || clock.Periods != 3 {
+        t.Errorf("expected 3 periods got %v", clock)
+    }
+    // Uses up two periods
+    if clock.Spend(25 * time.Second) || clock.Periods != 1 {
+        t.Errorf("expected 1 period got %v", clock)
+    }
+    if !clock.Spend(11 * time.Second) {
+        t.Errorf("expected flag got %v", clock)
+    }
+}
+
+func TestCanadian(t *testing.T) {
+    clock := (&Control{Kind: Canadian, Main: 1000, Stones: 2, Period: 10000}).NewClock()
+    clock.Spend(3 * time.Second)
+    if clock.Main != 0 || clock.Period != 8000 || clock.Stones != 1 {
+        t.Errorf("expected 8000 for 1 stone got %v", clock)
+    }
+    // Period done, a fresh one starts
+    clock.Spend(7 * time.Second)
+    if clock.Period != 10000 || clock.Stones != 2 || clock.Remaining() != 10 * time.Second {
+        t.Errorf("expected a fresh period got %v", clock)
+    }
+    if clock.Budget() != 4 * time.Second {
+        t.Errorf("expected 4s budget got %v", clock.Budget())
+    }
+    if !clock.Spend(11 * time.Second) {
+        t.Errorf("expected flag got %v", clock)
+    }
+}
+
+func TestAfter(t *testing.T) {
+    clock := (&Control{Kind: Fischer, Main: 10000, Increment: 2000}).NewClock()
+    after := clock.After(3 * time.Second)
+    if after.Main != 7000 || clock.Main != 10000 {
+        t.Errorf("expected 7000 left got %v", after)
+    }
+    clock = (&Control{Kind: Canadian, Stones: 5, Period: 10000}).NewClock()
+    after = clock.After(4 * time.Second)
+    if after.Period != 6000 || after.Stones != 5 {
+        t.Errorf("expected 6000 for 5 stones got %v", after)
+    }
+}
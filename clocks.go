@@ -0,0 +1,108 @@
+package main
+
+import (
+    "log"
+    "time"
+
+    ai "github.com/aorliche/web-nongrid-go/ai"
+    "github.com/aorliche/web-nongrid-go/clock"
+)
+
+// Game clocks, run by the server
+// A turn starts when the previous move is played, or when the last seat
+// is taken, and stops for scoring. Whoever is still thinking when their
+// time runs out loses on time, moves that come in too late lose as well
+
+// Clocks for every seat, nil for untimed games
+func NewClocks(control *clock.Control, nPlayers int) []*clock.Clock {
+    if control == nil {
+        return nil
+    }
+    clocks := make([]*clock.Clock, nPlayers)
+    for i := range clocks {
+        clocks[i] = control.NewClock()
+    }
+    return clocks
+}
+
+// Start the clock of the player to move
+// Call with the game locked
+func (game *Game) StartTurn() {
+    board := game.History[len(game.History)-1]
+    game.startTurn(board.Turn % board.NPlayers, len(game.History))
+}
+
+// moves is the length of the history during the turn
+func (game *Game) startTurn(seat int, moves int) {
+    if game.Clocks == nil || game.IsOver() {
+        return
+    }
+    game.StopClock()
+    game.turnStart = time.Now()
+    game.flagTimer = time.AfterFunc(game.Clocks[seat].Remaining(), func() {
+        game.Mutex.Lock()
+        defer game.Mutex.Unlock()
+        if game.IsOver() || game.State != Playing || len(game.History) != moves {
+            return
+        }
+        log.Println("Game", game.Key, colors[seat], "ran out of time")
+        game.Clocks[seat].Flagged = true
+        game.forfeit(seat, "Timeout")
+    })
+}
+
+// Call with the game locked
+func (game *Game) StopClock() {
+    if game.flagTimer != nil {
+        game.flagTimer.Stop()
+        game.flagTimer = nil
+    }
+    game.turnStart = time.Time{}
+}
+
+// Charge the player who made the move to next and start the next turn
+// Returns false if they ran out of time first, the caller ends the game
+// Call with the game locked, before next joins the history
+func (game *Game) PunchClock(next *ai.Board) bool {
+    if game.Clocks == nil || game.turnStart.IsZero() {
+        return true
+    }
+    board := game.History[len(game.History)-1]
+    seat := board.Turn % board.NPlayers
+    if game.Clocks[seat].Spend(time.Since(game.turnStart)) {
+        game.StopClock()
+        return false
+    }
+    game.startTurn(next.Turn % next.NPlayers, len(game.History)+1)
+    return true
+}
+
+// The clocks as they stand now, with the current turn charged so far
+// Call with the game locked
+func (game *Game) ClockState() []*clock.Clock {
+    if game.Clocks == nil {
+        return nil
+    }
+    board := game.History[len(game.History)-1]
+    clocks := make([]*clock.Clock, len(game.Clocks))
+    for i, c := range game.Clocks {
+        clocks[i] = c
+        if i == board.Turn % board.NPlayers && !game.turnStart.IsZero() {
+            clocks[i] = c.After(time.Since(game.turnStart))
+        }
+    }
+    return clocks
+}
+
+// Thinking time for an AI seat, its level's time unless the clock is shorter
+// Call with the game locked
+func (game *Game) ThinkMillis(seat int, level *ai.Level) int {
+    if game.Clocks == nil {
+        return level.TimeMillis
+    }
+    budget := int(game.Clocks[seat].Budget() / time.Millisecond)
+    if budget < level.TimeMillis {
+        return budget
+    }
+    return level.TimeMillis
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+    "errors"
+    "strconv"
+
+    ai "github.com/aorliche/web-nongrid-go/ai"
+    "github.com/aorliche/web-nongrid-go/clock"
+)
+
+// Exhibition games, every seat played by an AI with its own level
+// They get a Key like any other game, so they are listed, watched and saved
+// Moves are spaced out by Pace so spectators can follow
+
+const MaxPace = 60000
+
+// One level for each seat
+func ParseLevels(names []string, nPlayers int) ([]string, error) {
+    if len(names) != nPlayers {
+        return nil, errors.New("Exhibitions need a level for each of the " + strconv.Itoa(nPlayers) + " players")
+    }
+    levels := make([]string, nPlayers)
+    for i, name := range names {
+        level, err := ai.ParseLevel(name)
+        if err != nil {
+            return nil, err
+        }
+        levels[i] = level.Name
+    }
+    return levels, nil
+}
+
+func NewExhibition(req Request) (*Game, error) {
+    rules, err := ai.ParseRules(req.Rules)
+    if err != nil {
+        return nil, err
+    }
+    nPlayers, err := ParseNPlayers(req.NPlayers)
+    if err != nil {
+        return nil, err
+    }
+    levels, err := ParseLevels(req.Levels, nPlayers)
+    if err != nil {
+        return nil, err
+    }
+    if req.Pace < 0 || req.Pace > MaxPace {
+        return nil, errors.New("Pace must be 0 to " + strconv.Itoa(MaxPace) + " milliseconds")
+    }
+    if req.Board != "" {
+        req.BoardPlan, err = GetBoard(req.Board)
+        if err != nil {
+            return nil, errors.New("No board " + req.Board)
+        }
+    }
+    board, err := MakeBoard(req, nPlayers)
+    if err != nil {
+        return nil, errors.New("Bad board")
+    }
+    control, err := clock.ParseControl(req.Clock)
+    if err != nil {
+        return nil, err
+    }
+    board.Rules = rules
+    game := &Game{
+        BoardPlan: req.BoardPlan,
+        Json: BoardToJson(board),
+        Conns: make([]*Conn, nPlayers),
+        Player: colors[0],
+        Players: levels,
+        Pace: req.Pace,
+        Tokens: make([]string, nPlayers),
+        History: []*ai.Board{board},
+        State: Playing,
+        Clocks: NewClocks(control, nPlayers),
+        UndoPolicy: UndoNever,
+        Undos: make([]int, nPlayers),
+    }
+    return game, nil
+}
@@ -0,0 +1,56 @@
+package gtp
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "io"
+    "strings"
+)
+
+// Controller side of the protocol, for driving an engine in another process
+
+type Client struct {
+    r *bufio.Reader
+    w io.Writer
+}
+
+// Answers are read from r and commands written to w
+func NewClient(r io.Reader, w io.Writer) *Client {
+    return &Client{r: bufio.NewReader(r), w: w}
+}
+
+// Send one command and wait for the answer, a ? answer comes back as an error
+func (client *Client) Command(format string, args ...any) (string, error) {
+    _, err := fmt.Fprintf(client.w, format + "\n", args...)
+    if err != nil {
+        return "", err
+    }
+    lines := make([]string, 0, 1)
+    for {
+        line, err := client.r.ReadString('\n')
+        if err != nil {
+            return "", err
+        }
+        line = strings.TrimRight(line, "\r\n")
+        // Engines may send blank lines before the answer
+        if line == "" && len(lines) == 0 {
+            continue
+        }
+        if line == "" {
+            break
+        }
+        lines = append(lines, line)
+    }
+    status := lines[0][0]
+    if status != '=' && status != '?' {
+        return "", errors.New("bad answer " + lines[0])
+    }
+    // Drop the status and any id
+    lines[0] = strings.TrimLeft(lines[0][1:], "0123456789")
+    answer := strings.TrimSpace(strings.Join(lines, "\n"))
+    if status == '?' {
+        return "", errors.New(answer)
+    }
+    return answer, nil
+}
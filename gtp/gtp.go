@@ -0,0 +1,459 @@
+package gtp
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "math"
+    "math/rand"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+
+    ai "github.com/aorliche/web-nongrid-go/ai"
+    "github.com/aorliche/web-nongrid-go/clock"
+    "github.com/aorliche/web-nongrid-go/tiling"
+)
+
+// Go Text Protocol engine around ai.Search, for two players
+// Square grids use the usual vertices, A1 in the lower left without an I column
+// Other boards are loaded with the extension commands
+//   nongrid-loadboard NAME    plan saved under boards/, names can have spaces, or default for the browser's board
+//   nongrid-loadgraph JSON    {"Points": [...], "Neighbors": [[...], ...]} on one line
+// and their vertices are point indices
+// A play for the player not to move counts as a pass by the other one first
+
+const MaxBoardSize = 25
+
+var Commands = []string{
+    "protocol_version", "name", "version", "known_command", "list_commands", "quit",
+    "boardsize", "clear_board", "komi", "play", "genmove", "undo", "final_score",
+    "time_settings", "time_left", "showboard",
+    "nongrid-loadboard", "nongrid-loadgraph",
+}
+
+type Engine struct {
+    Level *ai.Level
+    // Where nongrid-loadboard looks
+    BoardsDir string
+    // Side of the grid, 0 for other boards
+    Size int
+    Rules ai.Rules
+    History []*ai.Board
+    // Nil when untimed, one per color
+    Clocks []*clock.Clock
+    rng *rand.Rand
+}
+
+func NewEngine(level *ai.Level, rng *rand.Rand) *Engine {
+    engine := &Engine{Level: level, BoardsDir: "boards", Rules: ai.DefaultRules, rng: rng}
+    engine.setBoard(ai.MakeTraditional(19, 2), 19)
+    return engine
+}
+
+func (engine *Engine) setBoard(board *ai.Board, size int) {
+    board.Rules = &engine.Rules
+    board.Rehash()
+    engine.Size = size
+    engine.History = []*ai.Board{board}
+}
+
+func (engine *Engine) Board() *ai.Board {
+    return engine.History[len(engine.History)-1]
+}
+
+// 0 for black, 1 for white
+func ParseColor(color string) (int, error) {
+    switch strings.ToLower(color) {
+        case "b", "black":
+            return 0, nil
+        case "w", "white":
+            return 1, nil
+    }
+    return 0, errors.New("invalid color")
+}
+
+const columns = "ABCDEFGHJKLMNOPQRSTUVWXYZ"
+
+// Point for a vertex on a grid of side size, or a point index when size is 0
+// -1 for a pass
+func ParseVertex(vertex string, size int, npts int) (int, error) {
+    vertex = strings.ToUpper(vertex)
+    if vertex == "PASS" {
+        return -1, nil
+    }
+    if size == 0 {
+        p, err := strconv.Atoi(vertex)
+        if err != nil || p < 0 || p >= npts {
+            return 0, errors.New("invalid vertex")
+        }
+        return p, nil
+    }
+    if len(vertex) < 2 {
+        return 0, errors.New("invalid vertex")
+    }
+    c := strings.IndexByte(columns, vertex[0])
+    row, err := strconv.Atoi(vertex[1:])
+    if c == -1 || c >= size || err != nil || row < 1 || row > size {
+        return 0, errors.New("invalid vertex")
+    }
+    // MakeTraditional counts rows from the top
+    return (size - row) * size + c, nil
+}
+
+func Vertex(p int, size int) string {
+    if p == -1 {
+        return "pass"
+    }
+    if size == 0 {
+        return strconv.Itoa(p)
+    }
+    r, c := p / size, p % size
+    return string(columns[c]) + strconv.Itoa(size - r)
+}
+
+// Side of the square grid MakeTraditional builds with these neighbors, 0 for other boards
+func GridSize(neighbors [][]int) int {
+    size := 1
+    for size * size < len(neighbors) {
+        size += 1
+    }
+    if size * size != len(neighbors) || size > MaxBoardSize {
+        return 0
+    }
+    grid := ai.MakeTraditional(size, 2).Neighbors
+    for p, ns := range neighbors {
+        if len(ns) != len(grid[p]) {
+            return 0
+        }
+        for _, n := range ns {
+            if !ai.Includes(grid[p], n) {
+                return 0
+            }
+        }
+    }
+    return size
+}
+
+func (engine *Engine) ParseVertex(vertex string) (int, error) {
+    return ParseVertex(vertex, engine.Size, len(engine.Board().Points))
+}
+
+func (engine *Engine) Vertex(p int) string {
+    return Vertex(p, engine.Size)
+}
+
+// Play for color, passing for the other player if it is their turn
+func (engine *Engine) play(color int, p int) error {
+    board := engine.Board()
+    if board.Turn % board.NPlayers != color {
+        next, err := board.Move(engine.History, -1, board.Turn % board.NPlayers)
+        if err != nil {
+            return err
+        }
+        engine.History = append(engine.History, next)
+        board = next
+    }
+    next, err := board.Move(engine.History, p, color)
+    if err != nil {
+        return err
+    }
+    engine.History = append(engine.History, next)
+    return nil
+}
+
+// Level's thinking time, cut down to what the clock allows
+func (engine *Engine) thinkMillis(color int) int {
+    millis := engine.Level.TimeMillis
+    if engine.Clocks != nil {
+        budget := int(engine.Clocks[color].Budget() / time.Millisecond)
+        if budget < millis {
+            millis = budget
+        }
+    }
+    return millis
+}
+
+func (engine *Engine) genmove(color int) string {
+    board := engine.Board()
+    if board.Turn % board.NPlayers != color {
+        next := board.Clone()
+        next.Turn += 1
+        engine.History = append(engine.History, next)
+    }
+    start := time.Now()
+    level := *engine.Level
+    level.TimeMillis = engine.thinkMillis(color)
+    next := level.Move(engine.History, color, engine.rng)
+    if engine.Clocks != nil {
+        engine.Clocks[color].Spend(time.Since(start))
+    }
+    p := ai.LastMove(engine.History, next)
+    engine.History = append(engine.History, next)
+    return engine.Vertex(p)
+}
+
+// Canadian byo-yomi in seconds as GTP has it, no byo-yomi time means absolute
+// and no time at all means untimed
+func (engine *Engine) timeSettings(main, byo, stones int) error {
+    if main < 0 || byo < 0 || stones < 0 {
+        return errors.New("syntax error")
+    }
+    var control *clock.Control
+    switch {
+        case main == 0 && byo == 0:
+            engine.Clocks = nil
+            return nil
+        case byo == 0 || stones == 0:
+            control = &clock.Control{Kind: clock.Absolute, Main: main*1000}
+        default:
+            control = &clock.Control{Kind: clock.Canadian, Main: main*1000, Period: byo*1000, Stones: stones}
+    }
+    engine.Clocks = []*clock.Clock{control.NewClock(), control.NewClock()}
+    return nil
+}
+
+// The controller's view of our clock, stones is 0 in main time
+func (engine *Engine) timeLeft(color int, seconds int, stones int) {
+    if engine.Clocks == nil {
+        return
+    }
+    c := engine.Clocks[color]
+    if stones == 0 {
+        c.Main = seconds*1000
+    } else {
+        c.Main = 0
+        c.Period = seconds*1000
+        c.Stones = stones
+    }
+}
+
+func (engine *Engine) finalScore() string {
+    scores := engine.Board().GetFinalScores()
+    diff := scores[0] - scores[1]
+    switch {
+        case diff > 0:
+            return "B+" + strconv.FormatFloat(diff, 'f', -1, 64)
+        case diff < 0:
+            return "W+" + strconv.FormatFloat(-diff, 'f', -1, 64)
+    }
+    return "0"
+}
+
+func (engine *Engine) showboard() string {
+    board := engine.Board()
+    stones := ".XO"
+    var b strings.Builder
+    if engine.Size == 0 {
+        for p, player := range board.Points {
+            b.WriteString(fmt.Sprintf("\n%d %c", p, stones[player+1]))
+        }
+        return b.String()
+    }
+    for r := 0; r < engine.Size; r++ {
+        b.WriteString(fmt.Sprintf("\n%2d ", engine.Size - r))
+        for c := 0; c < engine.Size; c++ {
+            b.WriteByte(stones[board.Points[r * engine.Size + c]+1])
+            b.WriteByte(' ')
+        }
+    }
+    b.WriteString("\n   ")
+    for c := 0; c < engine.Size; c++ {
+        b.WriteByte(columns[c])
+        b.WriteByte(' ')
+    }
+    return b.String()
+}
+
+func (engine *Engine) loadBoard(name string) error {
+    if name == "default" {
+        engine.setBoard(tiling.Default().ToBoard(2), 0)
+        return nil
+    }
+    if name == "" || strings.Contains(name, "/") || strings.Contains(name, "..") {
+        return errors.New("bad board name")
+    }
+    jsn, err := os.ReadFile(engine.BoardsDir + "/" + name)
+    if err != nil {
+        return errors.New("no such board")
+    }
+    t, err := tiling.FromJson(string(jsn))
+    if err != nil {
+        return errors.New("bad board plan")
+    }
+    engine.setBoard(t.ToBoard(2), 0)
+    return nil
+}
+
+func (engine *Engine) loadGraph(jsn string) error {
+    var graph struct {
+        Points []int
+        Neighbors [][]int
+    }
+    err := json.Unmarshal([]byte(jsn), &graph)
+    if err != nil || len(graph.Neighbors) == 0 {
+        return errors.New("bad graph")
+    }
+    npts := len(graph.Neighbors)
+    if graph.Points == nil {
+        graph.Points = make([]int, npts)
+        for i := range graph.Points {
+            graph.Points[i] = -1
+        }
+    }
+    if ai.CheckGraph(graph.Points, graph.Neighbors, 2) != nil {
+        return errors.New("bad graph")
+    }
+    engine.setBoard(&ai.Board{Points: graph.Points, Neighbors: graph.Neighbors, NPlayers: 2}, 0)
+    return nil
+}
+
+// Run one command, the answer is without the = or ? and the blank line
+func (engine *Engine) Command(name string, args []string) (string, error) {
+    arg := func(i int) string {
+        if i < len(args) {
+            return args[i]
+        }
+        return ""
+    }
+    number := func(i int) (int, error) {
+        n, err := strconv.Atoi(arg(i))
+        if err != nil {
+            return 0, errors.New("syntax error")
+        }
+        return n, nil
+    }
+    switch name {
+        case "protocol_version":
+            return "2", nil
+        case "name":
+            return "web-nongrid-go", nil
+        case "version":
+            return engine.Level.Name, nil
+        case "known_command":
+            for _, c := range Commands {
+                if c == arg(0) {
+                    return "true", nil
+                }
+            }
+            return "false", nil
+        case "list_commands":
+            return strings.Join(Commands, "\n"), nil
+        case "quit":
+            return "", nil
+        case "boardsize":
+            n, err := number(0)
+            if err != nil {
+                return "", err
+            }
+            if n < 1 || n > MaxBoardSize {
+                return "", errors.New("unacceptable size")
+            }
+            engine.setBoard(ai.MakeTraditional(n, 2), n)
+        case "clear_board":
+            first := engine.History[0]
+            board := &ai.Board{Points: make([]int, len(first.Points)), Neighbors: first.Neighbors, NPlayers: 2}
+            for i := range board.Points {
+                board.Points[i] = -1
+            }
+            engine.setBoard(board, engine.Size)
+        case "komi":
+            komi, err := strconv.ParseFloat(arg(0), 64)
+            if err != nil || math.IsNaN(komi) || math.IsInf(komi, 0) {
+                return "", errors.New("syntax error")
+            }
+            engine.Rules.Komi = komi
+        case "play":
+            color, err := ParseColor(arg(0))
+            if err != nil {
+                return "", errors.New("syntax error")
+            }
+            p, err := engine.ParseVertex(arg(1))
+            if err != nil {
+                return "", errors.New("syntax error")
+            }
+            err = engine.play(color, p)
+            if err != nil {
+                return "", errors.New("illegal move")
+            }
+        case "genmove":
+            color, err := ParseColor(arg(0))
+            if err != nil {
+                return "", errors.New("syntax error")
+            }
+            return engine.genmove(color), nil
+        case "undo":
+            if len(engine.History) < 2 {
+                return "", errors.New("cannot undo")
+            }
+            engine.History = engine.History[:len(engine.History)-1]
+        case "final_score":
+            return engine.finalScore(), nil
+        case "time_settings":
+            main, err1 := number(0)
+            byo, err2 := number(1)
+            stones, err3 := number(2)
+            if err1 != nil || err2 != nil || err3 != nil {
+                return "", errors.New("syntax error")
+            }
+            return "", engine.timeSettings(main, byo, stones)
+        case "time_left":
+            color, err := ParseColor(arg(0))
+            seconds, err2 := number(1)
+            stones, err3 := number(2)
+            if err != nil || err2 != nil || err3 != nil {
+                return "", errors.New("syntax error")
+            }
+            engine.timeLeft(color, seconds, stones)
+        case "showboard":
+            return engine.showboard(), nil
+        case "nongrid-loadboard":
+            return "", engine.loadBoard(strings.Join(args, " "))
+        case "nongrid-loadgraph":
+            return "", engine.loadGraph(strings.Join(args, " "))
+        default:
+            return "", errors.New("unknown command")
+    }
+    return "", nil
+}
+
+// Read commands until quit or the end of r
+func (engine *Engine) Run(r io.Reader, w io.Writer) error {
+    scanner := bufio.NewScanner(r)
+    // Graphs come on one long line
+    scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+    for scanner.Scan() {
+        line := scanner.Text()
+        if i := strings.IndexByte(line, '#'); i != -1 {
+            line = line[:i]
+        }
+        fields := strings.Fields(strings.ReplaceAll(line, "\t", " "))
+        if len(fields) == 0 {
+            continue
+        }
+        // Optional command id
+        id := ""
+        if _, err := strconv.Atoi(fields[0]); err == nil {
+            id, fields = fields[0], fields[1:]
+            if len(fields) == 0 {
+                continue
+            }
+        }
+        res, err := engine.Command(fields[0], fields[1:])
+        if err != nil {
+            _, err = fmt.Fprintf(w, "?%s %s\n\n", id, err.Error())
+        } else {
+            _, err = fmt.Fprintf(w, "=%s %s\n\n", id, res)
+        }
+        if err != nil {
+            return err
+        }
+        if fields[0] == "quit" {
+            return nil
+        }
+    }
+    return scanner.Err()
+}
@@ -0,0 +1,127 @@
+package gtp
+
+import (
+    "io"
+    "math/rand"
+    "strings"
+    "testing"
+
+    ai "github.com/aorliche/web-nongrid-go/ai"
+)
+
+func run(t *testing.T, engine *Engine, commands string) []string {
+    var out strings.Builder
+    err := engine.Run(strings.NewReader(commands), &out)
+    if err != nil {
+        t.Fatal(err)
+    }
+    replies := strings.Split(strings.TrimSuffix(out.String(), "\n\n"), "\n\n")
+    return replies
+}
+
+func fastEngine() *Engine {
+    level := &ai.Level{Name: "test", Algorithm: ai.AlphaBeta, Depth: 1, TimeMillis: 100, NTop: 5}
+    engine := NewEngine(level, rand.New(rand.NewSource(1)))
+    engine.BoardsDir = "../boards"
+    return engine
+}
+
+func TestProtocol(t *testing.T) {
+    engine := fastEngine()
+    replies := run(t, engine, "1 protocol_version\n# comment\n\nknown_command genmove\nfoo\n2 boardsize 30\nquit\nname\n")
+    expect := []string{"=1 2", "= true", "? unknown command", "?2 unacceptable size", "= "}
+    if len(replies) != len(expect) {
+        t.Fatalf("expect %q got %q", expect, replies)
+    }
+    for i := range expect {
+        if replies[i] != expect[i] {
+            t.Errorf("expect %q got %q", expect[i], replies[i])
+        }
+    }
+}
+
+func TestPlayUndoScore(t *testing.T) {
+    engine := fastEngine()
+    replies := run(t, engine, "boardsize 5\nclear_board\nkomi 0.5\nplay b A1\nplay b E5\nplay w A1\nundo\nfinal_score\nplay b C3\nplay b I3\n")
+    expect := []string{"= ", "= ", "= ", "= ", "= ", "? illegal move", "= ", "= B+24.5", "= ", "? syntax error"}
+    for i := range expect {
+        if replies[i] != expect[i] {
+            t.Errorf("command %d: expect %q got %q", i, expect[i], replies[i])
+        }
+    }
+    // A1 is the lower left corner, rows count from the top
+    board := engine.Board()
+    if board.Points[20] != 0 || board.Points[12] != 0 || len(engine.History) != 4 {
+        t.Errorf("expect black on 20 and 12 around a pass, got %v in %d positions", board.Points, len(engine.History))
+    }
+    if engine.Vertex(20) != "A1" || engine.Vertex(4) != "E5" || engine.Vertex(-1) != "pass" {
+        t.Errorf("bad vertices %s %s", engine.Vertex(20), engine.Vertex(4))
+    }
+}
+
+func TestGenmove(t *testing.T) {
+    engine := fastEngine()
+    replies := run(t, engine, "boardsize 5\ntime_settings 10 0 0\ntime_left b 5 0\ngenmove b\ngenmove b\n")
+    for _, reply := range replies[3:] {
+        if !strings.HasPrefix(reply, "= ") {
+            t.Fatalf("bad reply %q", reply)
+        }
+        if _, err := engine.ParseVertex(reply[2:]); err != nil {
+            t.Errorf("bad vertex %q", reply)
+        }
+    }
+    // White passed in between
+    if len(engine.History) != 4 {
+        t.Errorf("expect 4 positions got %d", len(engine.History))
+    }
+    if engine.Clocks == nil || engine.Clocks[0].Main > 5000 || engine.Clocks[1].Main != 10000 {
+        t.Errorf("expect black's clock to be set by time_left")
+    }
+}
+
+func TestGraphBoards(t *testing.T) {
+    engine := fastEngine()
+    replies := run(t, engine, "nongrid-loadgraph {\"Neighbors\": [[1], [0, 2], [1]]}\nplay b 1\nplay w 3\ngenmove w\nnongrid-loadboard 10x Almost Classic\nnongrid-loadboard ../go.mod\nnongrid-loadboard default\n")
+    expect := []string{"= ", "= ", "? syntax error", "", "= ", "? bad board name", "= "}
+    for i := range expect {
+        if i != 3 && replies[i] != expect[i] {
+            t.Errorf("command %d: expect %q got %q", i, expect[i], replies[i])
+        }
+    }
+    if engine.Size != 0 || len(engine.Board().Points) < 10 {
+        t.Errorf("expect the default board")
+    }
+    // One way neighbors and a stone without liberties
+    replies = run(t, engine, "nongrid-loadgraph {\"Neighbors\": [[1], []]}\nnongrid-loadgraph {\"Points\": [0, 1], \"Neighbors\": [[1], [0]]}\n")
+    for i, reply := range replies {
+        if reply != "? bad graph" {
+            t.Errorf("command %d: expect a bad graph got %q", i, reply)
+        }
+    }
+}
+
+// A client drives an engine over pipes the way ai/bot drives a subprocess
+func TestClient(t *testing.T) {
+    engine := fastEngine()
+    cmdR, cmdW := io.Pipe()
+    ansR, ansW := io.Pipe()
+    go func() {
+        engine.Run(cmdR, ansW)
+        ansW.Close()
+    }()
+    client := NewClient(ansR, cmdW)
+    if res, err := client.Command("boardsize %d", 3); err != nil || res != "" {
+        t.Errorf("expect an empty answer got %q %v", res, err)
+    }
+    if _, err := client.Command("play b Z9"); err == nil || err.Error() != "syntax error" {
+        t.Errorf("expect a syntax error got %v", err)
+    }
+    res, err := client.Command("showboard")
+    if err != nil || len(strings.Split(res, "\n")) != 4 {
+        t.Errorf("expect three rows and the columns got %q %v", res, err)
+    }
+    if GridSize(engine.Board().Neighbors) != 3 || GridSize(ai.MakeTraditional(3, 2).Neighbors[:4]) != 0 {
+        t.Errorf("expect grids to be recognized")
+    }
+    client.Command("quit")
+}
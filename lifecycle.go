@@ -0,0 +1,144 @@
+package main
+
+import (
+    "encoding/json"
+    "log"
+    "net/http"
+    "time"
+)
+
+// Game states
+// Waiting until every human seat has been taken, Playing, Scoring once everyone
+// passed, then Finished with a Result or Abandoned when everybody left first
+// The reaper abandons games nobody is connected to after IdleTimeout
+// and drops ended games from the registry, they stay saved on disk
+
+type GameState string
+
+const (
+    Waiting GameState = "waiting"
+    Playing GameState = "playing"
+    Scoring GameState = "scoring"
+    Finished GameState = "finished"
+    Abandoned GameState = "abandoned"
+)
+
+var States = []GameState{Waiting, Playing, Scoring, Finished, Abandoned}
+
+var IdleTimeout = 30 * time.Minute
+var ReapInterval = time.Minute
+
+func (state GameState) Over() bool {
+    return state == Finished || state == Abandoned
+}
+
+// Call with the game locked
+func (game *Game) IsOver() bool {
+    return game.State.Over()
+}
+
+// Why nobody can move now, empty while Playing
+// Call with the game locked
+func (game *Game) NotPlaying() string {
+    switch game.State {
+        case Playing: return ""
+        case Waiting: return "Waiting for players"
+        case Scoring: return "The game is being scored"
+    }
+    return "The game is over"
+}
+
+// Waiting while a human seat is free or a bot hasn't accepted, Playing otherwise
+// The clock starts once everyone is seated
+// Call with the game locked
+func (game *Game) UpdateSeated() {
+    if game.State != Waiting && game.State != Playing {
+        return
+    }
+    if game.FreeSeat(-1) == -1 && (game.State == Playing || game.BotsSeated()) {
+        game.State = Playing
+        if game.turnStart.IsZero() {
+            game.StartTurn()
+        }
+    } else {
+        game.State = Waiting
+    }
+}
+
+// Nobody is connected to a human seat
+// Call with the game locked
+func (game *Game) Empty() bool {
+    for _, conn := range game.Conns {
+        if conn != nil {
+            return false
+        }
+    }
+    return true
+}
+
+// Shut down everything still running for a game that has ended
+// Call with the game locked
+func (game *Game) End(state GameState, result string) {
+    game.State = state
+    game.Result = result
+    game.StopAI()
+    game.StopClock()
+    for seat := range game.timers {
+        game.StopAbandonTimer(seat)
+    }
+}
+
+// End without a result
+// Call with the game locked
+func (game *Game) Abandon() {
+    if game.IsOver() {
+        return
+    }
+    log.Println("Game", game.Key, "abandoned")
+    game.End(Abandoned, "Abandoned")
+    game.Save()
+    game.Broadcast(Request{Action: "Abandoned", Key: game.Key})
+}
+
+// One pass of the reaper
+func Reap(now time.Time) {
+    for _, game := range games.Games() {
+        game.Mutex.Lock()
+        idle := now.Sub(game.Active) > IdleTimeout
+        if idle && game.IsOver() {
+            games.Remove(game.Key)
+            log.Println("Game", game.Key, "expired")
+        } else if idle && game.Empty() {
+            game.Abandon()
+        }
+        game.Mutex.Unlock()
+    }
+}
+
+func Reaper() {
+    for now := range time.Tick(ReapInterval) {
+        Reap(now)
+    }
+}
+
+// Number of games in each state
+func CountStates() map[GameState]int {
+    counts := make(map[GameState]int)
+    for _, state := range States {
+        counts[state] = 0
+    }
+    for _, game := range games.Games() {
+        game.Mutex.Lock()
+        counts[game.State] += 1
+        game.Mutex.Unlock()
+    }
+    return counts
+}
+
+func AdminGames(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    err := json.NewEncoder(w).Encode(CountStates())
+    if err != nil {
+        log.Println(err)
+    }
+}
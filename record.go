@@ -0,0 +1,101 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+
+    ai "github.com/aorliche/web-nongrid-go/ai"
+    "github.com/aorliche/web-nongrid-go/sgf"
+)
+
+// Game records
+// Record sends the SGF of any game, running or saved on disk, and /record?key=
+// downloads it. Import replays a record with the rules and sends back its
+// final position, so a bad record is refused before anyone plays on it
+
+// The game so far, chat goes in the game comment
+// Call with the game locked
+func (game *Game) Record() *sgf.Record {
+    record := sgf.FromHistory(game.History)
+    record.BoardPlan = game.BoardPlan
+    for i, name := range game.Players {
+        if game.IsBot(i) {
+            record.Players[i] = game.Bots[i]
+        } else if name == "" {
+            record.Players[i] = Title(colors[i])
+        } else {
+            record.Players[i] = "Computer (" + name + ")"
+        }
+    }
+    record.Result = game.Result
+    lines := make([]string, len(game.Chat))
+    for i, line := range game.Chat {
+        lines[i] = line.Player + ": " + line.Payload
+    }
+    record.Comment = strings.Join(lines, "\n")
+    return record
+}
+
+// Record of a game in the registry, or of one only saved on disk
+func FindRecord(key int) (*sgf.Record, error) {
+    if game := games.Get(key); game != nil {
+        game.Mutex.Lock()
+        defer game.Mutex.Unlock()
+        return game.Record(), nil
+    }
+    saved, err := ReadSaved(gamePath(key))
+    if err != nil {
+        return nil, errors.New("Game not found")
+    }
+    game, err := saved.ToGame()
+    if err != nil {
+        return nil, err
+    }
+    return game.Record(), nil
+}
+
+// Parse a record and play it through, every move checked against its rules
+func ImportRecord(text string) (*sgf.Record, []*ai.Board, error) {
+    record, err := sgf.Decode(text)
+    if err != nil {
+        return nil, nil, err
+    }
+    if record.NPlayers > len(colors) {
+        return nil, nil, errors.New("Too many players")
+    }
+    history, err := record.Replay()
+    if err != nil {
+        return nil, nil, err
+    }
+    return record, history, nil
+}
+
+func RecordHandler(w http.ResponseWriter, r *http.Request) {
+    key, err := strconv.Atoi(r.URL.Query().Get("key"))
+    if err != nil {
+        http.Error(w, "Bad key", http.StatusBadRequest)
+        return
+    }
+    record, err := FindRecord(key)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    }
+    w.Header().Set("Content-Type", "application/x-go-sgf")
+    w.Header().Set("Content-Disposition", "attachment; filename=game-" + strconv.Itoa(key) + ".sgf")
+    _, err = w.Write([]byte(record.String()))
+    if err != nil {
+        log.Println(err)
+    }
+}
+
+// Reply to Import with the final position of the record
+func ImportReply(record *sgf.Record, history []*ai.Board) Request {
+    board := history[len(history)-1]
+    rules, _ := json.Marshal(board.GetRules())
+    return Request{Action: "Import", Key: -1, Payload: BoardToJson(board), BoardPlan: record.BoardPlan, Player: colors[board.Turn % board.NPlayers], Rules: string(rules), NPlayers: board.NPlayers, Seat: -1}
+}
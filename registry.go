@@ -0,0 +1,79 @@
+package main
+
+import (
+    "sort"
+    "sync"
+)
+
+// Every game the server knows about
+// Socket, ListSocket, GameLoop and the abandonment timers all run in their own
+// goroutines, so the map is only touched under the registry lock
+// and everything inside a Game only under Game.Mutex
+
+type Registry struct {
+    mutex sync.Mutex
+    games map[int]*Game
+    // Next key to hand out, above every key saved on disk
+    next int
+}
+
+func NewRegistry() *Registry {
+    return &Registry{games: make(map[int]*Game)}
+}
+
+func (reg *Registry) Get(key int) *Game {
+    reg.mutex.Lock()
+    defer reg.mutex.Unlock()
+    return reg.games[key]
+}
+
+// Give the game a fresh key and register it
+func (reg *Registry) Add(game *Game) int {
+    reg.mutex.Lock()
+    defer reg.mutex.Unlock()
+    game.Key = reg.next
+    reg.next += 1
+    reg.games[game.Key] = game
+    return game.Key
+}
+
+// Register a game under the key it was saved with
+func (reg *Registry) Put(game *Game) {
+    reg.mutex.Lock()
+    defer reg.mutex.Unlock()
+    reg.games[game.Key] = game
+    reg.reserve(game.Key)
+}
+
+// Never hand out key again, for finished games left on disk
+func (reg *Registry) Reserve(key int) {
+    reg.mutex.Lock()
+    defer reg.mutex.Unlock()
+    reg.reserve(key)
+}
+
+func (reg *Registry) reserve(key int) {
+    if key >= reg.next {
+        reg.next = key+1
+    }
+}
+
+func (reg *Registry) Remove(key int) {
+    reg.mutex.Lock()
+    defer reg.mutex.Unlock()
+    delete(reg.games, key)
+}
+
+// Snapshot of the games ordered by key
+func (reg *Registry) Games() []*Game {
+    reg.mutex.Lock()
+    defer reg.mutex.Unlock()
+    games := make([]*Game, 0, len(reg.games))
+    for _, game := range reg.games {
+        games = append(games, game)
+    }
+    sort.Slice(games, func(i, j int) bool {
+        return games[i].Key < games[j].Key
+    })
+    return games
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "log"
+    "strconv"
+    "sync"
+
+    "github.com/gorilla/websocket"
+    ai "github.com/aorliche/web-nongrid-go/ai"
+    "github.com/aorliche/web-nongrid-go/sgf"
+)
+
+// Review sessions
+// Review opens a game record, sent as SGF or the Key of a game, as a tree of
+// positions with its variations. Everyone in the session sees the same node:
+// any of them can step ReviewForward and ReviewBack, jump with ReviewGoTo,
+// play a new variation with ReviewPlay or annotate with ReviewComment,
+// and every step is broadcast to the whole session as ReviewState
+// ReviewEval has an AI search the move that led to the node and the move to play
+// from it, ReviewRecord sends the tree back as SGF with the variations and comments
+// Sessions have their own keys, shared with ReviewJoin, and go away when the last
+// connection leaves
+// Searches for reviews share the server with games, so they think no more than
+// MaxReviewLevel and only a few run at once, in each session and overall
+
+const MaxReviewLevel = "medium"
+const MaxSessionEvals = 2
+
+// Held by every running review evaluation
+var reviewEvals = make(chan bool, 4)
+
+type ReviewNode struct {
+    Id int
+    Board *ai.Board
+    // Move that led here, Point is -1 for a pass, the root has none
+    Move sgf.Move
+    Comment string
+    Parent *ReviewNode
+    // The first child is the main line
+    Children []*ReviewNode
+    Eval *Evaluation
+    evaluating bool
+}
+
+type Review struct {
+    Key int
+    Mutex sync.Mutex
+    // Players, rules and board of the record
+    Record *sgf.Record
+    Nodes []*ReviewNode
+    Current *ReviewNode
+    Conns []*Conn
+    // Evaluations running for this session
+    evaluations int
+}
+
+// AI opinion of a node
+type Evaluation struct {
+    Node int
+    Level string
+    // False at the root, which no move led to
+    Moved bool
+    // Eval of the move played and of the search's choice in its place
+    Point int
+    Value float64
+    Best int
+    BestValue float64
+    // Search's move from here, -1 for a pass
+    Next int
+}
+
+type ReviewChild struct {
+    Node int
+    Point int
+    Player string
+}
+
+// Payload of Review and ReviewState
+type ReviewState struct {
+    Node int
+    // -1 at the root
+    Parent int
+    Children []ReviewChild
+    // Nodes from the root to here
+    Path []int
+    // Point of the move that led here, -1 for a pass or at the root
+    LastMove int
+    Comment string
+    Position string
+    Eval *Evaluation
+}
+
+type ReviewRegistry struct {
+    mutex sync.Mutex
+    reviews map[int]*Review
+    next int
+}
+
+var reviews = &ReviewRegistry{reviews: make(map[int]*Review)}
+
+func (reg *ReviewRegistry) Get(key int) *Review {
+    reg.mutex.Lock()
+    defer reg.mutex.Unlock()
+    return reg.reviews[key]
+}
+
+func (reg *ReviewRegistry) Add(review *Review) int {
+    reg.mutex.Lock()
+    defer reg.mutex.Unlock()
+    review.Key = reg.next
+    reg.next += 1
+    reg.reviews[review.Key] = review
+    return review.Key
+}
+
+func (reg *ReviewRegistry) Remove(key int) {
+    reg.mutex.Lock()
+    defer reg.mutex.Unlock()
+    delete(reg.reviews, key)
+}
+
+// A session on the record in text, every move in every variation checked with the rules
+func NewReview(text string) (*Review, error) {
+    tree, err := sgf.Parse(text)
+    if err != nil {
+        return nil, err
+    }
+    record, err := sgf.FromRoot(tree)
+    if err != nil {
+        return nil, err
+    }
+    if record.NPlayers > len(colors) {
+        return nil, errors.New("Too many players")
+    }
+    board, err := record.Board()
+    if err != nil {
+        return nil, err
+    }
+    review := &Review{Record: record}
+    root := review.add(nil, board, sgf.Move{Point: -1})
+    root.Comment = record.Comment
+    review.Current = root
+    err = review.build(tree, root)
+    if err != nil {
+        return nil, err
+    }
+    return review, nil
+}
+
+// Add the variations below an SGF node
+func (review *Review) build(node *sgf.Node, parent *ReviewNode) error {
+    for _, child := range node.Children {
+        move, ok, err := review.Record.NodeMove(child)
+        if err != nil {
+            return err
+        }
+        at := parent
+        if ok {
+            // Nodes name their color, an imported tree may get it wrong
+            if move.Player != parent.Board.Turn % parent.Board.NPlayers {
+                return errors.New("Move " + strconv.Itoa(len(parent.Path())) + " is out of turn")
+            }
+            at, err = review.play(parent, move.Point)
+            if err != nil {
+                return errors.New("Move " + strconv.Itoa(len(at.Path())) + ": " + err.Error())
+            }
+            at.Comment = move.Comment
+        }
+        err = review.build(child, at)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (review *Review) add(parent *ReviewNode, board *ai.Board, move sgf.Move) *ReviewNode {
+    node := &ReviewNode{Id: len(review.Nodes), Board: board, Move: move, Parent: parent}
+    review.Nodes = append(review.Nodes, node)
+    if parent != nil {
+        parent.Children = append(parent.Children, node)
+    }
+    return node
+}
+
+// Nodes from the root down to this one
+func (node *ReviewNode) Path() []*ReviewNode {
+    path := make([]*ReviewNode, 0)
+    for n := node; n != nil; n = n.Parent {
+        path = append(path, n)
+    }
+    for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+        path[i], path[j] = path[j], path[i]
+    }
+    return path
+}
+
+// Positions on the way here, for the ko rule and searches
+func (node *ReviewNode) History() []*ai.Board {
+    path := node.Path()
+    history := make([]*ai.Board, len(path))
+    for i, n := range path {
+        history[i] = n.Board
+    }
+    return history
+}
+
+// The child for the player to move playing p, made if it is a new variation
+func (review *Review) play(parent *ReviewNode, p int) (*ReviewNode, error) {
+    board := parent.Board
+    me := board.Turn % board.NPlayers
+    for _, child := range parent.Children {
+        if child.Move.Point == p {
+            return child, nil
+        }
+    }
+    if p < -1 || p >= len(board.Points) {
+        return parent, errors.New("Point is off the board")
+    }
+    next, err := board.Move(parent.History(), p, me)
+    if err != nil {
+        return parent, err
+    }
+    return review.add(parent, next, sgf.Move{Player: me, Point: p}), nil
+}
+
+// Call with the review locked
+func (review *Review) State() ReviewState {
+    node := review.Current
+    state := ReviewState{
+        Node: node.Id,
+        Parent: -1,
+        Children: make([]ReviewChild, len(node.Children)),
+        LastMove: node.Move.Point,
+        Comment: node.Comment,
+        Position: BoardToJson(node.Board),
+        Eval: node.Eval,
+    }
+    if node.Parent != nil {
+        state.Parent = node.Parent.Id
+    }
+    for i, child := range node.Children {
+        state.Children[i] = ReviewChild{Node: child.Id, Point: child.Move.Point, Player: colors[child.Move.Player]}
+    }
+    for _, n := range node.Path() {
+        state.Path = append(state.Path, n.Id)
+    }
+    return state
+}
+
+// Call with the review locked
+func (review *Review) reply(action string) Request {
+    board := review.Current.Board
+    jsn, _ := json.Marshal(review.State())
+    return Request{Action: action, Key: review.Key, Payload: string(jsn), Player: colors[board.Turn % board.NPlayers], NPlayers: board.NPlayers}
+}
+
+// Call with the review locked
+func (review *Review) Broadcast(reply Request) {
+    jsn, _ := json.Marshal(reply)
+    for _, conn := range review.Conns {
+        err := conn.WriteMessage(websocket.TextMessage, jsn)
+        if err != nil {
+            log.Println(err)
+        }
+    }
+}
+
+// Send the board, rules and the current node to a new member
+// Call with the review locked
+func (review *Review) Join(conn *Conn) {
+    review.Conns = append(review.Conns, conn)
+    rules, _ := json.Marshal(review.Record.Rules)
+    reply := review.reply("Review")
+    reply.Seat = -1
+    reply.BoardPlan = review.Record.BoardPlan
+    reply.Rules = string(rules)
+    jsn, _ := json.Marshal(reply)
+    conn.WriteMessage(websocket.TextMessage, jsn)
+}
+
+func (review *Review) Leave(conn *Conn) {
+    review.Mutex.Lock()
+    defer review.Mutex.Unlock()
+    for i, c := range review.Conns {
+        if c == conn {
+            review.Conns = append(review.Conns[:i], review.Conns[i+1:]...)
+            break
+        }
+    }
+    if len(review.Conns) == 0 {
+        reviews.Remove(review.Key)
+    }
+}
+
+// The tree with its variations and comments
+// Call with the review locked
+func (review *Review) Tree() *sgf.Node {
+    record := *review.Record
+    record.Comment = review.Nodes[0].Comment
+    root := record.Root()
+    var add func(node *sgf.Node, rn *ReviewNode)
+    add = func(node *sgf.Node, rn *ReviewNode) {
+        for _, child := range rn.Children {
+            move := child.Move
+            move.Comment = child.Comment
+            add(node.AddChild(move.Node()), child)
+        }
+    }
+    add(root, review.Nodes[0])
+    return root
+}
+
+// Search with level for the player to move after history, -1 for a pass
+func searchPoint(history []*ai.Board, level *ai.Level) (*ai.Board, int) {
+    board := history[len(history)-1]
+    me := board.Turn % board.NPlayers
+    next := ai.Search(history, me, level.Depth, level.TimeMillis, level.NTop, level.Algorithm)
+    if next == nil {
+        next = board.Clone()
+        next.Turn += 1
+        return next, -1
+    }
+    return next, ai.LastMove(history, next)
+}
+
+// Evaluate node in the background and broadcast the result as ReviewEval
+// Call with the review locked
+func (review *Review) Evaluate(node *ReviewNode, level *ai.Level) error {
+    if node.evaluating {
+        return nil
+    }
+    limit, _ := ai.ParseLevel(MaxReviewLevel)
+    if level.Depth > limit.Depth || level.TimeMillis > limit.TimeMillis {
+        return errors.New("Reviews are evaluated at " + MaxReviewLevel + " or below")
+    }
+    if review.evaluations >= MaxSessionEvals {
+        return errors.New("Wait for the evaluations already running")
+    }
+    select {
+        case reviewEvals <- true:
+        default: return errors.New("The AI is busy, try again soon")
+    }
+    review.evaluations += 1
+    node.evaluating = true
+    // Searches build chains on the boards they are given, so they get copies
+    path := node.History()
+    history := make([]*ai.Board, len(path))
+    for i, board := range path {
+        history[i] = board.Clone()
+    }
+    go func() {
+        eval := &Evaluation{Node: node.Id, Level: level.Name, Next: -1}
+        n := len(history)
+        if n > 1 {
+            before := history[:n-1]
+            parent := before[n-2]
+            mover := parent.Turn % parent.NPlayers
+            stats := parent.GetStats()
+            best, point := searchPoint(before, level)
+            eval.Moved = true
+            eval.Point = ai.LastMove(before, history[n-1])
+            eval.Value = history[n-1].Eval(stats, mover)
+            eval.Best = point
+            eval.BestValue = best.Eval(stats, mover)
+        }
+        if last := history[n-1]; !last.GameOver(history) {
+            _, eval.Next = searchPoint(history, level)
+        }
+        <-reviewEvals
+        review.Mutex.Lock()
+        defer review.Mutex.Unlock()
+        review.evaluations -= 1
+        node.Eval = eval
+        node.evaluating = false
+        jsn, _ := json.Marshal(eval)
+        review.Broadcast(Request{Action: "ReviewEval", Key: review.Key, Payload: string(jsn)})
+    }()
+    return nil
+}
+
+// One of the stepping actions from a member of the session
+// Call with the review locked
+func (review *Review) Handle(conn *Conn, req Request) error {
+    node := review.Current
+    switch req.Action {
+        case "ReviewForward":
+            // Payload picks a variation, the main line by default
+            i, err := strconv.Atoi(req.Payload)
+            if err != nil {
+                i = 0
+            }
+            if i < 0 || i >= len(node.Children) {
+                return errors.New("No move to go forward to")
+            }
+            review.Current = node.Children[i]
+        case "ReviewBack":
+            if node.Parent == nil {
+                return errors.New("At the start")
+            }
+            review.Current = node.Parent
+        case "ReviewGoTo":
+            id, err := strconv.Atoi(req.Payload)
+            if err != nil || id < 0 || id >= len(review.Nodes) {
+                return errors.New("No such position")
+            }
+            review.Current = review.Nodes[id]
+        case "ReviewPlay":
+            var move AIMove
+            err := json.Unmarshal([]byte(req.Payload), &move)
+            if err != nil {
+                return err
+            }
+            next, err := review.play(node, move.Point)
+            if err != nil {
+                return err
+            }
+            review.Current = next
+        case "ReviewComment":
+            node.Comment = req.Payload
+        case "ReviewEval":
+            name := req.Level
+            if name == "" {
+                name = "medium"
+            }
+            level, err := ai.ParseLevel(name)
+            if err != nil {
+                return err
+            }
+            return review.Evaluate(node, level)
+        case "ReviewRecord":
+            jsn, _ := json.Marshal(Request{Action: "Record", Key: review.Key, Payload: review.Tree().String()})
+            conn.WriteMessage(websocket.TextMessage, jsn)
+            return nil
+        default:
+            return errors.New("Unknown review action " + req.Action)
+    }
+    review.Broadcast(review.reply("ReviewState"))
+    return nil
+}
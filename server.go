@@ -3,22 +3,31 @@ package main
 import (
     "bytes"
     "encoding/json"
+    "errors"
     //"fmt"
     "log"
+    "math/rand"
     "net/http"
     "os"
+    "strconv"
+    "strings"
     "sync"
+    "time"
 
     "github.com/gorilla/websocket"
     ai "github.com/aorliche/web-nongrid-go/ai"
+    "github.com/aorliche/web-nongrid-go/clock"
+    "github.com/aorliche/web-nongrid-go/tiling"
 )
 
-// Rules engine is handled in Javascript by player starting the game
-// If even the host player wants to make a move, they must still
-// send a move request to the server
-// The server will then relay the move request back to themself, and they
-// will send a response back to the server
-// This response will then be forwarded to the second player
+// The server keeps an ai.Board history for every game and is the rules authority
+// Clients play the move locally and send their new position to the server
+// The server finds the stone that was added, checks it with the rules in
+// package ai and broadcasts its own position to all players
+// Illegal moves are answered with an Error and the current position
+// Once every player has passed the game is scored: players toggle groups
+// as dead with MarkDead, and when every seat has sent AcceptScore the server
+// removes the dead stones and broadcasts the Result
 
 type Game struct {
     Key int
@@ -26,9 +35,42 @@ type Game struct {
     Json string
     BoardPlan string
     Mutex sync.Mutex
-    Conns []*websocket.Conn
-    RecvChan chan bool
+    // One for each seat, nil when nobody is connected or for AI
+    Conns []*Conn
+    // Empty for humans, the level for AI seats
+    Players []string
+    // Name of the bot playing or challenged to each seat, empty for everyone else
+    Bots []string
+    // Least milliseconds between AI moves
+    Pace int
+    // Reconnect token for each seat
+    Tokens []string
+    // Read-only observers
+    Watchers []*Conn
+    Chat []ChatLine
+    // Abandonment timers for disconnected seats
+    timers []*time.Timer
+    // Set up by StartAI for games with AI seats
+    // wake tells GameLoop a human moved, stop is closed when the game ends early
+    wake chan struct{}
+    stop chan struct{}
     History []*ai.Board
+    State GameState
+    // Last change, for the reaper
+    Active time.Time
+    // End of game phase
+    Dead []bool
+    Accepted []bool
+    Result string
+    // One per seat, nil for untimed games
+    Clocks []*clock.Clock
+    // Start of the current turn, zero while no clock runs
+    turnStart time.Time
+    flagTimer *time.Timer
+    // How AI seats answer takebacks, and how many each seat has had
+    UndoPolicy UndoPolicy
+    Undos []int
+    PendingUndo *UndoRequest
 }
 
 type Request struct {
@@ -37,6 +79,30 @@ type Request struct {
     Payload string
     Player string
     BoardPlan string
+    Algorithm string
+    Rules string
+    NPlayers int
+    // Seat of the player receiving the message
+    Seat int
+    // Reconnect token of that seat
+    Token string
+    // Stones placed for the human before a New-AI game starts
+    Handicap int
+    // Difficulty preset of the AI seats in New-AI
+    Level string
+    // Level of each seat and milliseconds between moves in an Exhibition
+    Levels []string
+    Pace int
+    // Saved board under boards/ to play on instead of BoardPlan
+    Board string
+    // Time control for New, New-AI and Exhibition as JSON, empty for untimed
+    Clock string
+    // Every seat's clock, sent with moves in timed games
+    Clocks []*clock.Clock
+    // Policy of the AI seats for UndoRequest in New-AI
+    Undo string
+    // Name of a bot for BotRegister and Challenge
+    Bot string
 }
 
 type PointsNeighbors struct {
@@ -48,28 +114,303 @@ type AIMove struct {
     Point int
 }
 
-func (pn *PointsNeighbors) ToBoard() *ai.Board {
-    return &ai.Board{
+// Payload of Score and Result
+type ScoreState struct {
+    Dead []int
+    Accepted []bool
+    Scores []float64
+    // Seat with the best score, -1 for a tie
+    Winner int
+    // Level of each AI seat, empty for humans
+    Players []string
+}
+
+// A point as saved and loaded by the Javascript board
+type JSPoint struct {
+    Id int `json:"id"`
+    Player *string `json:"player"`
+}
+
+// In seat order, black moves first
+var colors = []string{"black", "white", "red", "blue"}
+
+func Title(color string) string {
+    return strings.ToUpper(color[:1]) + color[1:]
+}
+
+// Zero means a regular two player game
+func ParseNPlayers(n int) (int, error) {
+    if n == 0 {
+        return 2, nil
+    }
+    if n < 2 || n > len(colors) {
+        return 0, errors.New("Games need 2 to " + strconv.Itoa(len(colors)) + " players")
+    }
+    return n, nil
+}
+
+// Seat for a color name, black when empty
+func ParseColor(color string, nPlayers int) (int, error) {
+    if color == "" {
+        return 0, nil
+    }
+    for i := 0; i < nPlayers; i++ {
+        if colors[i] == strings.ToLower(color) {
+            return i, nil
+        }
+    }
+    return -1, errors.New("No " + color + " player in this game")
+}
+
+// Clients send any graph, so check it before the rules ever see it
+func (pn *PointsNeighbors) ToBoard(nPlayers int) (*ai.Board, error) {
+    err := ai.CheckGraph(pn.Points, pn.Neighbors, nPlayers)
+    if err != nil {
+        return nil, err
+    }
+    board := &ai.Board{
         Points: pn.Points,
         Neighbors: pn.Neighbors,
-        NPlayers: 2,
+        NPlayers: nPlayers,
         Turn: 0,
     }
+    board.Rehash()
+    return board, nil
 }
 
-var games = make(map[int]*Game)
-var upgrader = websocket.Upgrader{} // Default options
+// Board from the points and neighbors a browser sent
+// or built here from the board plan (the default board without one)
+func MakeBoard(req Request, nPlayers int) (*ai.Board, error) {
+    if req.Payload != "" {
+        var pn PointsNeighbors 
+        err := json.Unmarshal([]byte(req.Payload), &pn)
+        if err != nil {
+            return nil, err
+        }
+        return pn.ToBoard(nPlayers)
+    }
+    if req.BoardPlan == "" {
+        return tiling.Default().ToBoard(nPlayers), nil
+    }
+    t, err := tiling.FromJson(req.BoardPlan)
+    if err != nil {
+        return nil, err
+    }
+    return t.ToBoard(nPlayers), nil
+}
+
+// Position in the format of JSPoint
+func BoardToJson(board *ai.Board) string {
+    pts := make([]JSPoint, len(board.Points))
+    for i, player := range board.Points {
+        pts[i].Id = i
+        if player != -1 {
+            pts[i].Player = &colors[player]
+        }
+    }
+    jsn, _ := json.Marshal(pts)
+    return string(jsn)
+}
+
+// Find the point where a client position adds a stone to board
+func FindMove(board *ai.Board, payload string) (int, error) {
+    var pts []JSPoint
+    err := json.Unmarshal([]byte(payload), &pts)
+    if err != nil {
+        return -1, err
+    }
+    move := -1
+    for _, pt := range pts {
+        if pt.Player == nil || pt.Id < 0 || pt.Id >= len(board.Points) || board.Points[pt.Id] != -1 {
+            continue
+        }
+        if move != -1 {
+            return -1, errors.New("More than one stone placed")
+        }
+        move = pt.Id
+    }
+    if move == -1 {
+        return -1, errors.New("No stone placed")
+    }
+    return move, nil
+}
 
-func NextGameIdx() int {
-    max := -1
-    for key := range games {
-        if key > max {
-            max = key
+func SendError(conn *Conn, key int, msg string) {
+    reply := Request{Action: "Error", Key: key, Payload: msg}
+    jsn, _ := json.Marshal(reply)
+    conn.WriteMessage(websocket.TextMessage, jsn)
+}
+
+// Tell a client its move was rejected and send it the current position
+func RejectMove(conn *Conn, game *Game, err error) {
+    log.Println(err)
+    SendError(conn, game.Key, err.Error())
+    board := game.History[len(game.History)-1]
+    reply := Request{Action: "Move", Key: game.Key, Payload: BoardToJson(board), Player: colors[board.Turn % board.NPlayers]}
+    jsn, _ := json.Marshal(reply)
+    conn.WriteMessage(websocket.TextMessage, jsn)
+}
+
+// Send to every seated player and observer, AI seats have no connection
+func (game *Game) Broadcast(reply Request) {
+    jsn, _ := json.Marshal(reply)
+    conns := make([]*Conn, 0, len(game.Conns) + len(game.Watchers))
+    conns = append(conns, game.Conns...)
+    conns = append(conns, game.Watchers...)
+    for _, conn := range conns {
+        if conn != nil {
+            err := conn.WriteMessage(websocket.TextMessage, jsn)
+            if err != nil {
+                log.Println(err)
+            }
         }
     }
-    return max+1
 }
 
+// Enter the end of game phase with the unconditionally dead stones marked
+// Call with the game locked
+func (game *Game) StartScoring() {
+    board := game.History[len(game.History)-1]
+    game.State = Scoring
+    game.Dead = make([]bool, len(board.Points))
+    for p, status := range board.GetLifeStatus() {
+        game.Dead[p] = status == ai.Dead
+    }
+    game.Accepted = make([]bool, board.NPlayers)
+    game.StopClock()
+    game.ResetAccepted()
+    // Games between AIs are over right away
+    game.CheckAccepted()
+}
+
+// Humans have to accept again after a change
+// AI seats go along with any marking
+func (game *Game) ResetAccepted() {
+    for i := range game.Accepted {
+        game.Accepted[i] = game.Players[i] != ""
+    }
+}
+
+// End the game once every seat accepted, otherwise send the scoring state
+// Call with the game locked
+func (game *Game) CheckAccepted() {
+    done := true
+    for _, accepted := range game.Accepted {
+        done = done && accepted
+    }
+    if !done {
+        game.BroadcastScore("Score")
+        return
+    }
+    result := "Draw"
+    if state := game.ScoreState(); state.Winner != -1 {
+        result = Title(colors[state.Winner]) + " wins"
+    }
+    game.End(Finished, result)
+    game.Save()
+    game.BroadcastScore("Result")
+}
+
+func (game *Game) ScoreState() ScoreState {
+    board := game.History[len(game.History)-1]
+    dead := make([]int, 0)
+    for p, d := range game.Dead {
+        if d {
+            dead = append(dead, p)
+        }
+    }
+    scores := board.RemoveStones(dead).GetFinalScores()
+    winner := 0
+    for i, score := range scores {
+        if score > scores[winner] {
+            winner = i
+        } else if i != winner && score == scores[winner] {
+            winner = -1
+            break
+        }
+    }
+    return ScoreState{Dead: dead, Accepted: game.Accepted, Scores: scores, Winner: winner, Players: game.Players}
+}
+
+func (game *Game) BroadcastScore(action string) {
+    state := game.ScoreState()
+    jsn, _ := json.Marshal(state)
+    winner := ""
+    if state.Winner != -1 {
+        winner = Title(colors[state.Winner])
+    }
+    game.Broadcast(Request{Action: action, Key: game.Key, Payload: string(jsn), Player: winner})
+}
+
+func (game *Game) HasAI() bool {
+    for _, name := range game.Players {
+        if name != "" {
+            return true
+        }
+    }
+    return false
+}
+
+// A human seat nobody is connected to, the wanted one if it is free
+// -1 if the game is full
+func (game *Game) FreeSeat(want int) int {
+    // Seats with a token are held for their dropped player to Resume
+    free := func(seat int) bool {
+        return seat >= 0 && seat < len(game.Conns) && game.Conns[seat] == nil && game.Players[seat] == "" && game.Tokens[seat] == ""
+    }
+    if free(want) {
+        return want
+    }
+    for seat := range game.Conns {
+        if free(seat) {
+            return seat
+        }
+    }
+    return -1
+}
+
+// Start the game loop that plays the AI seats
+// Call with the game locked
+func (game *Game) StartAI() {
+    game.wake = make(chan struct{}, 1)
+    game.stop = make(chan struct{})
+    go GameLoop(game)
+}
+
+// Game ended without being played out, GameLoop should quit
+// Call with the game locked
+func (game *Game) StopAI() {
+    if game.stop != nil {
+        close(game.stop)
+        game.stop = nil
+    }
+}
+
+// A move or pass in a game with AI seats, everyone is sent the point played
+// Call with the game locked
+func (game *Game) Played(next *ai.Board) {
+    n := len(game.History)
+    game.History = append(game.History, next)
+    game.Json = BoardToJson(next)
+    game.Player = colors[next.Turn % next.NPlayers]
+    aimove := AIMove{Point: ai.LastMove(game.History[:n], next)}
+    // Player who just moved
+    player := colors[(next.Turn - 1) % next.NPlayers]
+    jsn, _ := json.Marshal(aimove)
+    game.Broadcast(Request{Action: "Move-AI", Key: game.Key, Payload: string(jsn), Player: player, Clocks: game.ClockState()})
+    if next.GameOver(game.History) {
+        game.StartScoring()
+    }
+    game.Save()
+    select {
+        case game.wake <- struct{}{}:
+        default:
+    }
+}
+
+var games = NewRegistry()
+var upgrader = websocket.Upgrader{} // Default options
+
 func GetBoards() []string {
     dir, err := os.Open("boards")
     if err != nil {
@@ -91,7 +432,15 @@ func GetBoards() []string {
     return boards
 }
 
+// Names come from clients, keep them inside boards/
+func BadBoardName(name string) bool {
+    return name == "" || strings.ContainsAny(name, "/\\") || strings.HasPrefix(name, ".")
+}
+
 func GetBoard(name string) (string, error) {
+    if BadBoardName(name) {
+        return "", errors.New("Bad board name")
+    }
     dat, err := os.ReadFile("boards/" + name)
     if err != nil {
         log.Println(err)
@@ -101,6 +450,9 @@ func GetBoard(name string) (string, error) {
 }
 
 func AddBoard(name string, json string) error {
+    if BadBoardName(name) {
+        return errors.New("Bad board name")
+    }
     err := os.WriteFile("boards/" + name, []byte(json), 0644)
     if err != nil {
         log.Println(err)
@@ -191,6 +543,16 @@ func BoardsSocket(w http.ResponseWriter, r *http.Request) {
     }
 }
 
+type ListEntry struct {
+    Key int
+    // Has a free seat
+    Open bool
+    // Level of each AI seat, empty for humans
+    Players []string
+    // Name of each bot seat
+    Bots []string
+}
+
 func ListSocket(w http.ResponseWriter, r *http.Request) {
     conn, err := upgrader.Upgrade(w, r, nil)
     if err != nil {
@@ -213,15 +575,16 @@ func ListSocket(w http.ResponseWriter, r *http.Request) {
         json.NewDecoder(bytes.NewBuffer(msg)).Decode(&req)
         switch req.Action {
             case "List":
-                keys := make([]int, 0)
-                for key := range games {
-                    // Check if game has not been joined by two players
-                    game := games[key]
-                    if len(game.Conns) < 2 {
-                        keys = append(keys, key)
+                // Every game in progress can be watched, open ones joined
+                list := make([]ListEntry, 0)
+                for _, game := range games.Games() {
+                    game.Mutex.Lock()
+                    if !game.IsOver() {
+                        list = append(list, ListEntry{Key: game.Key, Open: game.FreeSeat(-1) != -1, Players: game.Players, Bots: game.Bots})
                     }
+                    game.Mutex.Unlock()
                 }
-                jsn, _ := json.Marshal(keys)
+                jsn, _ := json.Marshal(list)
                 err = conn.WriteMessage(websocket.TextMessage, jsn)
                 if err != nil {
                     log.Println(err)
@@ -231,52 +594,125 @@ func ListSocket(w http.ResponseWriter, r *http.Request) {
     }
 }
 
-func GameLoop(game *Game, recvChan chan bool, sendChan chan bool) {
-    getLastMove := func(prev *ai.Board, cur *ai.Board) int {
-        for i := 0; i < len(prev.Points); i++ {
-            if cur.Points[i] != -1 && prev.Points[i] != cur.Points[i] {
-                return i
-            }
+// Every seated player gets the position, the next player and their own seat and token
+// Call with the game locked
+func (game *Game) BroadcastJoin() {
+    rules, _ := json.Marshal(game.History[0].GetRules())
+    for seat, c := range game.Conns {
+        if c == nil {
+            continue
         }
-        return -1
+        reply := Request{Action: "Join", Key: game.Key, Payload: game.Json, BoardPlan: game.BoardPlan, Player: game.Player, Rules: string(rules), NPlayers: game.History[0].NPlayers, Seat: seat, Token: game.Tokens[seat], Clocks: game.ClockState()}
+        jsn, _ := json.Marshal(reply)
+        c.WriteMessage(websocket.TextMessage, jsn)
     }
-    board := game.History[0]
+}
+
+// Plays the AI seats of a game until it is over
+// Searches run on a copy of the history without the game locked,
+// the move is thrown away if the game moved on in the meantime
+func GameLoop(game *Game) {
+    game.Mutex.Lock()
+    wake, stop := game.wake, game.stop
+    pace := time.Duration(game.Pace) * time.Millisecond
+    game.Mutex.Unlock()
+    rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+    last := time.Now()
     for {
-        sendChan <- true
-        if board.GameOver(game.History) {
-            log.Println("game over")
-            log.Println(board.GetScores())
-            break
+        game.Mutex.Lock()
+        board := game.History[len(game.History)-1]
+        if game.IsOver() || game.State == Scoring || board.GameOver(game.History) {
+            game.Mutex.Unlock()
+            return
         }
-        keepPlaying := <- recvChan
-        if !keepPlaying {
-            sendChan <- false
-            break
+        me := board.Turn % board.NPlayers
+        name := game.Players[me]
+        // An undo can bring the history back to the same length
+        history := make([]*ai.Board, len(game.History))
+        copy(history, game.History)
+        // Search builds chains on the last board, don't share it
+        history[len(history)-1] = board.Clone()
+        game.Mutex.Unlock()
+        // Human to move
+        if name == "" {
+            select {
+                case <- wake:
+                case <- stop:
+                    return
+            }
+            continue
+        }
+        level, err := ai.ParseLevel(name)
+        if err != nil {
+            log.Println(err)
+            level = ai.Levels[0]
         }
-        prev := game.History[len(game.History) - 2]
-        board = game.History[len(game.History) - 1]
-        aimove := AIMove{Point: getLastMove(prev, board)}
+        // Timed games think within the clock
+        think := *level
         game.Mutex.Lock()
-        player := "white"
-        if board.Turn % 2 == 1 {
-            player = "black"
+        think.TimeMillis = game.ThinkMillis(me, level)
+        game.Mutex.Unlock()
+        next := think.Move(history, me, rng)
+        // Slow down for spectators
+        if wait := pace - time.Since(last); wait > 0 {
+            select {
+                case <- time.After(wait):
+                case <- stop:
+                    return
+            }
+        }
+        last = time.Now()
+        game.Mutex.Lock()
+        if !game.IsOver() && game.History[len(game.History)-1] == board {
+            if game.PunchClock(next) {
+                game.Played(next)
+            } else {
+                game.forfeit(me, "Timeout")
+            }
         }
-        jsn2, _ := json.Marshal(aimove)
-        reply := Request{Action: "Move-AI", Key: game.Key, Payload: string(jsn2), Player: player}
-        jsn, _ := json.Marshal(reply)
-        game.Conns[0].WriteMessage(websocket.TextMessage, jsn)
         game.Mutex.Unlock()
     }
 }
 
 func Socket(w http.ResponseWriter, r *http.Request) {
-    conn, err := upgrader.Upgrade(w, r, nil)
+    ws, err := upgrader.Upgrade(w, r, nil)
     if err != nil {
         log.Println(err)
         return
     }
+    conn := &Conn{Conn: ws}
     defer conn.Close()
     player := -1
+    // Game the player is seated in
+    var joined *Game
+    // Game the connection observes
+    var watching *Game
+    // Review session the connection is in
+    var reviewing *Review
+    // Set once the connection registers as a bot
+    var bot *Bot
+    defer func() {
+        if bot != nil {
+            bots.Remove(bot)
+            // Nobody is left to answer a challenge
+            if game := bots.Game(bot); game != nil {
+                game.Mutex.Lock()
+                if seat := game.BotSeat(bot.Name); seat != -1 {
+                    game.Decline(seat)
+                }
+                game.Mutex.Unlock()
+            }
+        }
+        if reviewing != nil {
+            reviewing.Leave(conn)
+        }
+        if joined != nil {
+            joined.Disconnect(player, conn)
+        }
+        if watching != nil {
+            watching.Unwatch(conn)
+        }
+    }()
     for {
         msgType, msg, err := conn.ReadMessage()
         if err != nil {
@@ -292,157 +728,571 @@ func Socket(w http.ResponseWriter, r *http.Request) {
         json.NewDecoder(bytes.NewBuffer(msg)).Decode(&req)
         switch req.Action {
             case "Concede":
-                game := games[req.Key]
-                if game == nil {
+                game := games.Get(req.Key)
+                if game == nil || game != joined {
                     log.Println("Game not found")
                     continue    
                 }
-                // AI game
-                if game.Json == "" {
-                    game.RecvChan <- false
+                game.Forfeit(player, "Concede")
+            case "Pass":
+                game := games.Get(req.Key)
+                if game == nil || game != joined {
+                    log.Println("Game not found")
+                    continue    
                 }
-                winner := "White"
-                if player == 1 {
-                    winner = "Black"
+                game.Mutex.Lock()
+                if msg := game.NotPlaying(); msg != "" {
+                    SendError(conn, game.Key, msg)
+                    game.Mutex.Unlock()
+                    continue
                 }
-                for _, conn := range game.Conns {
-                    // AI Game
-                    if conn == nil {
-                        continue
-                    }
-                    reply := Request{Action: "Concede", Key: game.Key, Payload: winner}
-                    jsn, _ := json.Marshal(reply)
-                    conn.WriteMessage(websocket.TextMessage, jsn)
+                board := game.History[len(game.History)-1]
+                nextBoard, err := board.Move(game.History, -1, player)
+                if err != nil {
+                    log.Println(err)
+                    SendError(conn, game.Key, err.Error())
+                    game.Mutex.Unlock()
+                    continue
                 }
-            case "Pass":
-                game := games[req.Key]
-                if game == nil {
+                if !game.PunchClock(nextBoard) {
+                    game.forfeit(player, "Timeout")
+                    game.Mutex.Unlock()
+                    continue
+                }
+                if game.wake != nil {
+                    game.Played(nextBoard)
+                    game.Mutex.Unlock()
+                    continue
+                }
+                game.History = append(game.History, nextBoard)
+                game.Player = colors[nextBoard.Turn % nextBoard.NPlayers]
+                game.Broadcast(Request{Action: "Pass", Key: game.Key, Player: game.Player, Payload: Title(colors[player]), Clocks: game.ClockState()})
+                if nextBoard.GameOver(game.History) {
+                    game.StartScoring()
+                }
+                game.Save()
+                game.Mutex.Unlock()
+            // Toggle the group at Point between dead and alive
+            case "MarkDead":
+                game := games.Get(req.Key)
+                if game == nil || game != joined {
                     log.Println("Game not found")
                     continue    
                 }
-                next, passing := "white", "Black";
-                if player == 1 {
-                    next, passing = "black", "White"
+                var mark AIMove
+                err := json.Unmarshal([]byte(req.Payload), &mark)
+                if err != nil {
+                    log.Println(err)
+                    continue
                 }
-                game.Player = next
-                for _, conn := range game.Conns {
-                    reply := Request{Action: "Pass", Key: game.Key, Player: next, Payload: passing}
-                    jsn, _ := json.Marshal(reply)
-                    conn.WriteMessage(websocket.TextMessage, jsn)
+                game.Mutex.Lock()
+                board := game.History[len(game.History)-1]
+                if game.State != Scoring || mark.Point < 0 || mark.Point >= len(board.Points) || board.Points[mark.Point] == -1 {
+                    SendError(conn, game.Key, "Nothing to mark")
+                    game.Mutex.Unlock()
+                    continue
                 }
-            case "Chat":
-                game := games[req.Key]
-                if game == nil {
+                dead := !game.Dead[mark.Point]
+                for _, p := range board.Chain(mark.Point) {
+                    game.Dead[p] = dead
+                }
+                game.ResetAccepted()
+                game.BroadcastScore("Score")
+                game.Mutex.Unlock()
+            case "AcceptScore":
+                game := games.Get(req.Key)
+                if game == nil || game != joined {
                     log.Println("Game not found")
                     continue    
                 }
-                p := "Black";
-                if player == 1 {
-                    p = "White"
+                game.Mutex.Lock()
+                if game.State != Scoring {
+                    SendError(conn, game.Key, "The game is not being scored")
+                    game.Mutex.Unlock()
+                    continue
                 }
-                for _, conn := range game.Conns {
-                    reply := Request{Action: "Chat", Key: game.Key, Player: p, Payload: req.Payload}
-                    jsn, _ := json.Marshal(reply)
-                    conn.WriteMessage(websocket.TextMessage, jsn)
+                game.Accepted[player] = true
+                game.CheckAccepted()
+                game.Mutex.Unlock()
+            case "Chat":
+                game := games.Get(req.Key)
+                if game == nil || game != joined {
+                    log.Println("Game not found")
+                    continue    
                 }
+                game.Mutex.Lock()
+                line := ChatLine{Player: Title(colors[player]), Payload: req.Payload}
+                game.Chat = append(game.Chat, line)
+                game.Broadcast(Request{Action: "Chat", Key: game.Key, Player: line.Player, Payload: line.Payload})
+                game.Save()
+                game.Mutex.Unlock()
             case "New":  
-                if player != -1 {
+                if player != -1 || watching != nil || reviewing != nil {
                     log.Println("Player already joined")
                     continue
                 }
+                rules, err := ai.ParseRules(req.Rules)
+                if err != nil {
+                    log.Println(err)
+                    SendError(conn, -1, err.Error())
+                    continue
+                }
+                nPlayers, err := ParseNPlayers(req.NPlayers)
+                if err != nil {
+                    log.Println(err)
+                    SendError(conn, -1, err.Error())
+                    continue
+                }
+                board, err := MakeBoard(req, nPlayers)
+                if err != nil {
+                    log.Println(err)
+                    SendError(conn, -1, "Bad board")
+                    continue
+                }
+                control, err := clock.ParseControl(req.Clock)
+                if err != nil {
+                    log.Println(err)
+                    SendError(conn, -1, err.Error())
+                    continue
+                }
                 player = 0
-                game := &Game{Key: NextGameIdx(), BoardPlan: req.BoardPlan, Json: req.Payload, Conns: make([]*websocket.Conn, 1), Player: "black"}
+                board.Rules = rules
+                game := &Game{BoardPlan: req.BoardPlan, Json: BoardToJson(board), Conns: make([]*Conn, nPlayers), Player: "black", State: Waiting}
+                game.Players = make([]string, nPlayers)
+                game.Tokens = make([]string, nPlayers)
+                game.Tokens[0] = NewToken()
+                game.Clocks = NewClocks(control, nPlayers)
+                game.UndoPolicy = UndoAlways
+                game.Undos = make([]int, nPlayers)
+                game.History = []*ai.Board{board}
                 game.Conns[0] = conn
-                games[game.Key] = game
-                reply := Request{Action: "New", Key: game.Key} 
+                game.UpdateSeated()
+                game.Mutex.Lock()
+                games.Add(game)
+                joined = game
+                game.Save()
+                reply := Request{Action: "New", Key: game.Key, NPlayers: nPlayers, Token: game.Tokens[0]} 
                 jsn, _ := json.Marshal(reply)
                 conn.WriteMessage(websocket.TextMessage, jsn)
+                game.Mutex.Unlock()
             case "New-AI":
-                player = 0
-                // Two cons, one nil to prevent people from joining
-                game := &Game{Key: NextGameIdx(), BoardPlan: req.BoardPlan, Json: "", Conns: make([]*websocket.Conn, 2), Player: "black"}
-                game.Conns[0] = conn
-                games[game.Key] = game
-                reply := Request{Action: "New", Key: game.Key} 
-                jsn, _ := json.Marshal(reply)
-                conn.WriteMessage(websocket.TextMessage, jsn)
-                var pn PointsNeighbors 
-                err := json.Unmarshal([]byte(req.Payload), &pn)
+                if player != -1 || watching != nil || reviewing != nil {
+                    log.Println("Player already joined")
+                    continue
+                }
+                // Older clients only name an algorithm
+                name := req.Level
+                if name == "" {
+                    name = req.Algorithm
+                }
+                level, err := ai.ParseLevel(name)
                 if err != nil {
                     log.Println(err)
+                    SendError(conn, -1, err.Error())
                     continue
                 }
-                // Start ai 
-                board := pn.ToBoard()
-                game.History = []*ai.Board{board}
-                recvChan := make(chan bool)
-                sendChan := make(chan bool)
-                game.RecvChan = recvChan
-                for i := 0; i < 2; i++ {
-                    if i == player {
-                        continue
+                rules, err := ai.ParseRules(req.Rules)
+                if err != nil {
+                    log.Println(err)
+                    SendError(conn, -1, err.Error())
+                    continue
+                }
+                nPlayers, err := ParseNPlayers(req.NPlayers)
+                if err != nil {
+                    log.Println(err)
+                    SendError(conn, -1, err.Error())
+                    continue
+                }
+                board, err := MakeBoard(req, nPlayers)
+                if err != nil {
+                    log.Println(err)
+                    SendError(conn, -1, "Bad board")
+                    continue
+                }
+                control, err := clock.ParseControl(req.Clock)
+                if err != nil {
+                    log.Println(err)
+                    SendError(conn, -1, err.Error())
+                    continue
+                }
+                undo, err := ParseUndoPolicy(req.Undo)
+                if err != nil {
+                    log.Println(err)
+                    SendError(conn, -1, err.Error())
+                    continue
+                }
+                // Player asks for the human's color
+                seat, err := ParseColor(req.Player, nPlayers)
+                if err != nil {
+                    log.Println(err)
+                    SendError(conn, -1, err.Error())
+                    continue
+                }
+                board.Rules = rules
+                board, err = board.PlaceHandicap(seat, req.Handicap)
+                if err != nil {
+                    log.Println(err)
+                    SendError(conn, -1, err.Error())
+                    continue
+                }
+                player = seat
+                game := &Game{BoardPlan: req.BoardPlan, Json: BoardToJson(board), Conns: make([]*Conn, nPlayers), Player: colors[board.Turn % nPlayers], State: Waiting}
+                game.Conns[player] = conn
+                // Every other seat is taken by an AI
+                game.Players = make([]string, nPlayers)
+                for i := range game.Players {
+                    if i != player {
+                        game.Players[i] = level.Name
                     }
-                    go ai.Loop(i, &game.History, sendChan, recvChan, 5, 2000, 200)
                 }
-                go GameLoop(game, recvChan, sendChan)
+                game.Tokens = make([]string, nPlayers)
+                game.Tokens[player] = NewToken()
+                game.Clocks = NewClocks(control, nPlayers)
+                game.UndoPolicy = undo
+                game.Undos = make([]int, nPlayers)
+                game.History = []*ai.Board{board}
+                game.UpdateSeated()
+                game.Mutex.Lock()
+                games.Add(game)
+                joined = game
+                game.Save()
+                // The position has the handicap, the AI may move first
+                reply := Request{Action: "New", Key: game.Key, NPlayers: nPlayers, Token: game.Tokens[player], Seat: player, Payload: game.Json, Player: game.Player, Clocks: game.ClockState()}
+                jsn, _ := json.Marshal(reply)
+                conn.WriteMessage(websocket.TextMessage, jsn)
+                // Start ai 
+                game.StartAI()
+                game.Mutex.Unlock()
             case "Join": 
-                if player != -1 {
+                if player != -1 || watching != nil || reviewing != nil {
                     log.Println("Player already joined")
                     continue
                 }
-                player = 1
-                game := games[req.Key]
-                if len(game.Conns) < 2 {
-                    game.Conns = append(game.Conns, conn)
-                } else {
-                    log.Println("Game full")
+                game := games.Get(req.Key)
+                if game == nil {
+                    log.Println("Game not found")
+                    continue    
+                }
+                game.Mutex.Lock()
+                // Seat asks for a seat when rejoining a saved game
+                seat := game.FreeSeat(req.Seat)
+                if seat == -1 || game.IsOver() {
+                    SendError(conn, game.Key, "Game full")
+                    game.Mutex.Unlock()
                     continue
                 }
-                // Next player
-                reply := Request{Action: "Join", Key: game.Key, Payload: game.Json, BoardPlan: game.BoardPlan, Player: game.Player}
-                jsn, _ := json.Marshal(reply)
-                game.Conns[0].WriteMessage(websocket.TextMessage, jsn)
-                game.Conns[1].WriteMessage(websocket.TextMessage, jsn)
+                player = seat
+                joined = game
+                game.Conns[seat] = conn
+                game.Tokens[seat] = NewToken()
+                game.StopAbandonTimer(seat)
+                game.UpdateSeated()
+                game.Save()
+                game.BroadcastJoin()
+                game.Mutex.Unlock()
+            // Observe without a seat
+            case "Watch":
+                if player != -1 || watching != nil || reviewing != nil {
+                    log.Println("Already in a game")
+                    continue
+                }
+                game := games.Get(req.Key)
+                if game == nil {
+                    SendError(conn, req.Key, "Game not found")
+                    continue    
+                }
+                game.Mutex.Lock()
+                watching = game
+                game.Watch(conn)
+                game.Mutex.Unlock()
+            // AI against AI, the connection watches
+            case "Exhibition":
+                if player != -1 || watching != nil || reviewing != nil {
+                    log.Println("Already in a game")
+                    continue
+                }
+                game, err := NewExhibition(req)
+                if err != nil {
+                    log.Println(err)
+                    SendError(conn, -1, err.Error())
+                    continue
+                }
+                game.Mutex.Lock()
+                games.Add(game)
+                watching = game
+                game.Save()
+                game.Watch(conn)
+                game.StartTurn()
+                game.StartAI()
+                game.Mutex.Unlock()
+            // Take a seat back with its token after a dropped connection
+            case "Resume":
+                if player != -1 || watching != nil || reviewing != nil {
+                    log.Println("Player already joined")
+                    continue
+                }
+                game := games.Get(req.Key)
+                if game == nil {
+                    SendError(conn, req.Key, "Game not found")
+                    continue    
+                }
+                game.Mutex.Lock()
+                seat := game.TokenSeat(req.Token)
+                if seat == -1 {
+                    SendError(conn, game.Key, "Bad reconnect token")
+                    game.Mutex.Unlock()
+                    continue
+                }
+                player = seat
+                joined = game
+                game.Resume(seat, conn)
+                game.Mutex.Unlock()
             case "Move":
-                game := games[req.Key]
+                game := games.Get(req.Key)
+                if game == nil || game != joined {
+                    log.Println("Game not found")
+                    continue    
+                }
                 game.Mutex.Lock()
-                game.Json = req.Payload
-                if player == 0 {
-                    game.Player = "white"
+                if msg := game.NotPlaying(); msg != "" {
+                    RejectMove(conn, game, errors.New(msg))
+                    game.Mutex.Unlock()
+                    continue
+                }
+                board := game.History[len(game.History)-1]
+                p, err := FindMove(board, req.Payload)
+                var nextBoard *ai.Board
+                if err == nil {
+                    nextBoard, err = board.Move(game.History, p, player)
+                }
+                if err != nil {
+                    RejectMove(conn, game, err)
+                    game.Mutex.Unlock()
+                    continue
+                }
+                if !game.PunchClock(nextBoard) {
+                    game.forfeit(player, "Timeout")
+                } else if game.wake != nil {
+                    // Rejoined AI game
+                    game.Played(nextBoard)
+                } else {
+                    game.History = append(game.History, nextBoard)
+                    game.Json = BoardToJson(nextBoard)
+                    game.Player = colors[nextBoard.Turn % nextBoard.NPlayers]
+                    game.Broadcast(Request{Action: "Move", Key: game.Key, Payload: game.Json, Player: game.Player, Clocks: game.ClockState()})
+                    game.Save()
+                }
+                game.Mutex.Unlock()
+            // SGF of any game, it doesn't have to be ours
+            case "Record":
+                record, err := FindRecord(req.Key)
+                if err != nil {
+                    SendError(conn, req.Key, err.Error())
+                    continue
+                }
+                jsn, _ := json.Marshal(Request{Action: "Record", Key: req.Key, Payload: record.String()})
+                conn.WriteMessage(websocket.TextMessage, jsn)
+            case "Import":
+                record, history, err := ImportRecord(req.Payload)
+                if err != nil {
+                    log.Println(err)
+                    SendError(conn, -1, err.Error())
+                    continue
+                }
+                jsn, _ := json.Marshal(ImportReply(record, history))
+                conn.WriteMessage(websocket.TextMessage, jsn)
+            // Study a record, Payload is SGF or else Key is a game
+            case "Review", "ReviewJoin":
+                if player != -1 || watching != nil || reviewing != nil {
+                    log.Println("Already in a game")
+                    continue
+                }
+                var review *Review
+                if req.Action == "ReviewJoin" {
+                    review = reviews.Get(req.Key)
+                    if review == nil {
+                        SendError(conn, req.Key, "Review not found")
+                        continue
+                    }
                 } else {
-                    game.Player = "black"
+                    text := req.Payload
+                    if text == "" {
+                        record, err := FindRecord(req.Key)
+                        if err != nil {
+                            SendError(conn, req.Key, err.Error())
+                            continue
+                        }
+                        text = record.String()
+                    }
+                    review, err = NewReview(text)
+                    if err != nil {
+                        log.Println(err)
+                        SendError(conn, -1, err.Error())
+                        continue
+                    }
+                    reviews.Add(review)
+                }
+                review.Mutex.Lock()
+                reviewing = review
+                review.Join(conn)
+                review.Mutex.Unlock()
+            case "ReviewForward", "ReviewBack", "ReviewGoTo", "ReviewPlay", "ReviewComment", "ReviewEval", "ReviewRecord":
+                if reviewing == nil || reviewing.Key != req.Key {
+                    log.Println("Review not found")
+                    continue
+                }
+                reviewing.Mutex.Lock()
+                err = reviewing.Handle(conn, req)
+                if err != nil {
+                    SendError(conn, req.Key, err.Error())
+                }
+                reviewing.Mutex.Unlock()
+            case "UndoRequest", "UndoAccept", "UndoDecline":
+                game := games.Get(req.Key)
+                if game == nil || game != joined {
+                    log.Println("Game not found")
+                    continue    
+                }
+                game.Mutex.Lock()
+                if req.Action == "UndoRequest" {
+                    err = game.RequestUndo(player)
+                } else {
+                    err = game.AnswerUndo(player, req.Action == "UndoAccept")
+                }
+                if err != nil {
+                    SendError(conn, game.Key, err.Error())
+                }
+                game.Mutex.Unlock()
+            case "BotRegister":
+                if bot != nil || player != -1 || watching != nil || reviewing != nil {
+                    log.Println("Already in a game")
+                    continue
+                }
+                bot, err = bots.Register(req.Bot, conn)
+                if err != nil {
+                    SendError(conn, -1, err.Error())
+                    continue
+                }
+                jsn, _ := json.Marshal(Request{Action: "BotRegister", Bot: bot.Name})
+                conn.WriteMessage(websocket.TextMessage, jsn)
+            case "Bots":
+                list, _ := json.Marshal(bots.List())
+                jsn, _ := json.Marshal(Request{Action: "Bots", Payload: string(list)})
+                conn.WriteMessage(websocket.TextMessage, jsn)
+            // Human asks a bot for a game
+            case "Challenge":
+                if bot != nil || player != -1 || watching != nil || reviewing != nil {
+                    log.Println("Already in a game")
+                    continue
+                }
+                game, seat, botSeat, err := NewChallenge(req)
+                if err != nil {
+                    log.Println(err)
+                    SendError(conn, -1, err.Error())
+                    continue
+                }
+                challenged, err := bots.Reserve(req.Bot, game)
+                if err != nil {
+                    SendError(conn, -1, err.Error())
+                    continue
                 }
-                reply := Request{Action: "Move", Key: game.Key, Payload: game.Json, Player: game.Player}
+                game.Mutex.Lock()
+                player = seat
+                joined = game
+                game.Conns[seat] = conn
+                games.Add(game)
+                game.Save()
+                reply := Request{Action: "New", Key: game.Key, NPlayers: len(game.Conns), Token: game.Tokens[seat], Seat: seat, Payload: game.Json, Player: game.Player, Clocks: game.ClockState()}
                 jsn, _ := json.Marshal(reply)
-                game.Conns[0].WriteMessage(websocket.TextMessage, jsn)
-                if len(game.Conns) == 2 {
-                    game.Conns[1].WriteMessage(websocket.TextMessage, jsn)
+                conn.WriteMessage(websocket.TextMessage, jsn)
+                game.SendChallenge(challenged, botSeat)
+                game.StartAbandonTimer(botSeat)
+                // Moves go through GameLoop like in computer games, so everyone gets the point played
+                game.StartAI()
+                game.Mutex.Unlock()
+            case "BotAccept", "BotDecline":
+                if bot == nil {
+                    log.Println("Not a bot")
+                    continue
                 }
+                game := games.Get(req.Key)
+                if game == nil {
+                    SendError(conn, req.Key, "Game not found")
+                    continue
+                }
+                if req.Action == "BotDecline" {
+                    game.Mutex.Lock()
+                    if seat := game.BotSeat(bot.Name); seat != -1 {
+                        game.Decline(seat)
+                    }
+                    game.Mutex.Unlock()
+                    continue
+                }
+                // Leave the last game behind
+                if joined != nil && joined != game {
+                    joined.Mutex.Lock()
+                    over := joined.IsOver()
+                    joined.Mutex.Unlock()
+                    if !over {
+                        SendError(conn, req.Key, "Finish your game first")
+                        continue
+                    }
+                    joined.Disconnect(player, conn)
+                    joined = nil
+                    player = -1
+                }
+                game.Mutex.Lock()
+                seat := game.BotSeat(bot.Name)
+                if seat == -1 || game.State != Waiting || game.Conns[seat] != nil {
+                    SendError(conn, game.Key, "No challenge to accept")
+                    game.Mutex.Unlock()
+                    continue
+                }
+                player = seat
+                joined = game
+                game.Conns[seat] = conn
+                game.StopAbandonTimer(seat)
+                game.UpdateSeated()
+                game.Save()
+                game.BroadcastJoin()
+                game.SendBotGame(conn, seat)
                 game.Mutex.Unlock()
             case "Move-AI":
-                game := games[req.Key]
-                game.Mutex.Lock()
+                game := games.Get(req.Key)
+                if game == nil || game != joined {
+                    log.Println("Game not found")
+                    continue    
+                }
                 var aimove AIMove
                 err := json.Unmarshal([]byte(req.Payload), &aimove)
                 if err != nil {
                     log.Println(err)
                     continue
                 }
-                if player == 0 {
-                    game.Player = "white"
-                } else {
-                    game.Player = "black"
+                game.Mutex.Lock()
+                if msg := game.NotPlaying(); msg != "" {
+                    SendError(conn, game.Key, msg)
+                    game.Mutex.Unlock()
+                    continue
+                }
+                if game.wake == nil {
+                    SendError(conn, game.Key, "Not a computer game")
+                    game.Mutex.Unlock()
+                    continue
                 }
                 // Make the move
-                nextBoard := game.History[len(game.History)-1].Clone()
-                nextBoard.Turn += 1
-                if aimove.Point != -1 {
-                    nextBoard.Points[aimove.Point] = player
-                    nextBoard.CullCaptured(1-player)
-                    nextBoard.CullCaptured(player)
+                board := game.History[len(game.History)-1]
+                nextBoard, err := board.Move(game.History, aimove.Point, player)
+                if err != nil {
+                    RejectMove(conn, game, err)
+                    game.Mutex.Unlock()
+                    continue
+                }
+                if game.PunchClock(nextBoard) {
+                    game.Played(nextBoard)
+                } else {
+                    game.forfeit(player, "Timeout")
                 }
-                game.History = append(game.History, nextBoard)
-                game.RecvChan <- true
                 game.Mutex.Unlock()
         }
     }
@@ -491,8 +1341,12 @@ func ServeLocalFiles(dirs []string) {
 func main() {
     log.SetFlags(0)
     ServeLocalFiles([]string{"", "/js", "/css"})
+    LoadGames()
+    go Reaper()
     http.HandleFunc("/ws", Socket)
     http.HandleFunc("/list", ListSocket)
     http.HandleFunc("/boards", BoardsSocket)
+    http.HandleFunc("/admin/games", AdminGames)
+    http.HandleFunc("/record", RecordHandler)
     log.Fatal(http.ListenAndServe(":8001", nil))
 }
@@ -0,0 +1,692 @@
+package main
+
+import (
+    "encoding/json"
+    "io"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+    ai "github.com/aorliche/web-nongrid-go/ai"
+)
+
+// Run with go test -race, every test drives the server from several clients at once
+
+// Socket goroutines of earlier tests can outlive them, so settings are only changed here
+func TestMain(m *testing.M) {
+    dir, err := os.MkdirTemp("", "games")
+    if err != nil {
+        log.Fatal(err)
+    }
+    gamesDir = dir
+    // Quick AI for the tests
+    ai.Levels = append(ai.Levels, &ai.Level{Name: "test", Algorithm: ai.AlphaBeta, Depth: 2, TimeMillis: 50, NTop: 10})
+    AbandonTimeout = 200 * time.Millisecond
+    code := m.Run()
+    os.RemoveAll(dir)
+    os.Exit(code)
+}
+
+func startServer(t *testing.T) string {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/ws", Socket)
+    mux.HandleFunc("/list", ListSocket)
+    mux.HandleFunc("/record", RecordHandler)
+    srv := httptest.NewServer(mux)
+    t.Cleanup(srv.Close)
+    return "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+}
+
+type client struct {
+    t *testing.T
+    conn *websocket.Conn
+}
+
+func dial(t *testing.T, url string) *client {
+    conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { conn.Close() })
+    return &client{t: t, conn: conn}
+}
+
+func (c *client) send(req Request) {
+    err := c.conn.WriteJSON(req)
+    if err != nil {
+        c.t.Fatal(err)
+    }
+}
+
+// Next message with the action, skipping others
+func (c *client) expect(action string) Request {
+    for {
+        c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+        var req Request
+        err := c.conn.ReadJSON(&req)
+        if err != nil {
+            c.t.Fatalf("waiting for %s: %v", action, err)
+        }
+        if req.Action == action {
+            return req
+        }
+    }
+}
+
+func smallBoard() string {
+    board := ai.MakeTraditional(3, 2)
+    jsn, _ := json.Marshal(PointsNeighbors{Points: board.Points, Neighbors: board.Neighbors})
+    return string(jsn)
+}
+
+// Client position with a stone added on p
+func withStone(position string, p int, color string) string {
+    var pts []JSPoint
+    json.Unmarshal([]byte(position), &pts)
+    pts[p].Player = &color
+    jsn, _ := json.Marshal(pts)
+    return string(jsn)
+}
+
+// New game with both seats taken
+func startGame(t *testing.T, url string) (*client, *client, int) {
+    black := dial(t, url)
+    black.send(Request{Action: "New", NPlayers: 2, Payload: smallBoard()})
+    key := black.expect("New").Key
+    white := dial(t, url)
+    white.send(Request{Action: "Join", Key: key})
+    white.expect("Join")
+    black.expect("Join")
+    return black, white, key
+}
+
+func TestConcurrentNew(t *testing.T) {
+    url := startServer(t)
+    n := 20
+    keys := make(chan int, n)
+    var wg sync.WaitGroup
+    for i := 0; i < n; i++ {
+        c := dial(t, url)
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            c.conn.WriteJSON(Request{Action: "New", NPlayers: 2, Payload: smallBoard()})
+            var req Request
+            c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+            c.conn.ReadJSON(&req)
+            keys <- req.Key
+        }()
+    }
+    // List while games are being made
+    lister := dial(t, strings.TrimSuffix(url, "/ws") + "/list")
+    for i := 0; i < 5; i++ {
+        lister.send(Request{Action: "List"})
+        var list []ListEntry
+        lister.conn.ReadJSON(&list)
+    }
+    wg.Wait()
+    close(keys)
+    seen := make(map[int]bool)
+    for key := range keys {
+        if seen[key] {
+            t.Errorf("key %d handed out twice", key)
+        }
+        seen[key] = true
+    }
+    if len(seen) != n {
+        t.Errorf("expect %d games got %d keys", n, len(seen))
+    }
+    for key := range seen {
+        if games.Get(key) == nil {
+            t.Errorf("game %d not registered", key)
+        }
+    }
+}
+
+func TestPlayAndWatch(t *testing.T) {
+    url := startServer(t)
+    black, white, key := startGame(t, url)
+    watcher := dial(t, url)
+    watcher.send(Request{Action: "Watch", Key: key})
+    position := watcher.expect("Watch").Payload
+    // Observers are read-only
+    watcher.send(Request{Action: "Pass", Key: key})
+    black.send(Request{Action: "Move", Key: key, Payload: withStone(position, 4, "black")})
+    move := watcher.expect("Move")
+    if move.Player != "white" {
+        t.Errorf("expect white to move got %s", move.Player)
+    }
+    white.expect("Move")
+    // Out of turn
+    black.send(Request{Action: "Move", Key: key, Payload: withStone(move.Payload, 0, "black")})
+    black.expect("Error")
+    white.send(Request{Action: "Chat", Key: key, Payload: "hi"})
+    chat := watcher.expect("Chat")
+    if chat.Player != "White" || chat.Payload != "hi" {
+        t.Errorf("expect White: hi got %s: %s", chat.Player, chat.Payload)
+    }
+    white.send(Request{Action: "Concede", Key: key})
+    concede := watcher.expect("Concede")
+    if concede.Payload != "Black" {
+        t.Errorf("expect Black to win got %s", concede.Payload)
+    }
+}
+
+// Nobody moves before every seat is taken
+func TestMoveWhileWaiting(t *testing.T) {
+    url := startServer(t)
+    black := dial(t, url)
+    black.send(Request{Action: "New", NPlayers: 2, Payload: smallBoard()})
+    created := black.expect("New")
+    black.send(Request{Action: "Pass", Key: created.Key})
+    if reply := black.expect("Error"); reply.Payload != "Waiting for players" {
+        t.Errorf("expect Waiting for players got %s", reply.Payload)
+    }
+    black.send(Request{Action: "Move", Key: created.Key, Payload: withStone(BoardToJson(ai.MakeTraditional(3, 2)), 4, "black")})
+    black.expect("Error")
+    if move := black.expect("Move"); strings.Contains(move.Payload, "black") {
+        t.Errorf("expect the empty board back got %s", move.Payload)
+    }
+    white := dial(t, url)
+    white.send(Request{Action: "Join", Key: created.Key})
+    black.expect("Join")
+    black.send(Request{Action: "Pass", Key: created.Key})
+    black.expect("Pass")
+}
+
+// Both clients play the same point at the same time, exactly one move is taken
+func TestConcurrentMoves(t *testing.T) {
+    url := startServer(t)
+    black, white, key := startGame(t, url)
+    game := games.Get(key)
+    game.Mutex.Lock()
+    position := game.Json
+    game.Mutex.Unlock()
+    var wg sync.WaitGroup
+    for i, c := range []*client{black, white} {
+        wg.Add(1)
+        go func(c *client, color string) {
+            defer wg.Done()
+            c.conn.WriteJSON(Request{Action: "Move", Key: key, Payload: withStone(position, 4, color)})
+        }(c, colors[i])
+    }
+    wg.Wait()
+    black.expect("Move")
+    game.Mutex.Lock()
+    n := len(game.History)
+    game.Mutex.Unlock()
+    if n != 2 {
+        t.Errorf("expect 2 positions got %d", n)
+    }
+}
+
+func TestAIGame(t *testing.T) {
+    url := startServer(t)
+    human := dial(t, url)
+    human.send(Request{Action: "New-AI", Level: "test", NPlayers: 3, Payload: smallBoard()})
+    key := human.expect("New").Key
+    human.send(Request{Action: "Move-AI", Key: key, Payload: `{"Point": 4}`})
+    // Our move and both AI replies
+    for _, color := range colors[:3] {
+        move := human.expect("Move-AI")
+        if move.Player != color {
+            t.Errorf("expect %s to have moved got %s", color, move.Player)
+        }
+    }
+    // The list reports the AI level
+    lister := dial(t, strings.TrimSuffix(url, "/ws") + "/list")
+    lister.send(Request{Action: "List"})
+    var list []ListEntry
+    lister.conn.ReadJSON(&list)
+    found := false
+    for _, entry := range list {
+        if entry.Key == key {
+            found = true
+            if entry.Open || len(entry.Players) != 3 || entry.Players[1] != "test" {
+                t.Errorf("bad list entry %v", entry)
+            }
+        }
+    }
+    if !found {
+        t.Errorf("game %d not listed", key)
+    }
+    watcher := dial(t, url)
+    watcher.send(Request{Action: "Watch", Key: key})
+    watcher.expect("Watch")
+    human.send(Request{Action: "Concede", Key: key})
+    watcher.expect("Concede")
+    game := games.Get(key)
+    game.Mutex.Lock()
+    n := len(game.History)
+    game.Mutex.Unlock()
+    // The game loop has quit and plays no more
+    time.Sleep(200 * time.Millisecond)
+    game.Mutex.Lock()
+    defer game.Mutex.Unlock()
+    if len(game.History) != n {
+        t.Errorf("moves played after concession")
+    }
+}
+
+// Human plays white with two handicap stones, black's AI opens
+func TestAIColorAndHandicap(t *testing.T) {
+    url := startServer(t)
+    human := dial(t, url)
+    human.send(Request{Action: "New-AI", Level: "test", NPlayers: 2, Payload: smallBoard(), Player: "white", Handicap: 2})
+    reply := human.expect("New")
+    if reply.Seat != 1 || reply.Player != "black" {
+        t.Errorf("expect seat 1 with black to move got %d %s", reply.Seat, reply.Player)
+    }
+    var pts []JSPoint
+    json.Unmarshal([]byte(reply.Payload), &pts)
+    if pts[4].Player == nil || *pts[4].Player != "white" {
+        t.Errorf("expect a white handicap stone on the center")
+    }
+    if move := human.expect("Move-AI"); move.Player != "black" {
+        t.Errorf("expect black to open got %s", move.Player)
+    }
+    other := dial(t, url)
+    other.send(Request{Action: "New-AI", Level: "test", Player: "green"})
+    other.expect("Error")
+}
+
+// Graphs that would crash the rules are turned away before a game exists
+func TestBadBoard(t *testing.T) {
+    url := startServer(t)
+    c := dial(t, url)
+    bad := []PointsNeighbors{
+        {Points: []int{-1, -1}, Neighbors: [][]int{{1}, {0, 2}}},
+        {Points: []int{-1, -1}, Neighbors: [][]int{{0, 1}, {0}}},
+        {Points: []int{-1, -1}, Neighbors: [][]int{{1}, {}}},
+        {Points: []int{-1, 2}, Neighbors: [][]int{{1}, {0}}},
+        {Points: []int{-1}, Neighbors: [][]int{{1}, {0}}},
+        {},
+    }
+    for _, pn := range bad {
+        jsn, _ := json.Marshal(pn)
+        for _, action := range []string{"New", "New-AI"} {
+            c.send(Request{Action: action, Level: "test", NPlayers: 2, Payload: string(jsn)})
+            if reply := c.expect("Error"); reply.Payload != "Bad board" {
+                t.Errorf("expect Bad board for %s with %s got %s", action, jsn, reply.Payload)
+            }
+        }
+    }
+    // The server is still answering
+    c.send(Request{Action: "New", NPlayers: 2, Payload: smallBoard()})
+    c.expect("New")
+}
+
+func TestResumeAndForfeit(t *testing.T) {
+    url := startServer(t)
+    black := dial(t, url)
+    black.send(Request{Action: "New", NPlayers: 2, Payload: smallBoard()})
+    reply := black.expect("New")
+    key, token := reply.Key, reply.Token
+    white := dial(t, url)
+    white.send(Request{Action: "Join", Key: key})
+    white.expect("Join")
+    black.conn.Close()
+    white.expect("Disconnect")
+    black = dial(t, url)
+    black.send(Request{Action: "Resume", Key: key, Token: "wrong"})
+    black.expect("Error")
+    black.send(Request{Action: "Resume", Key: key, Token: token})
+    if seat := black.expect("Resume").Seat; seat != 0 {
+        t.Errorf("expect seat 0 got %d", seat)
+    }
+    white.expect("Reconnect")
+    white.conn.Close()
+    forfeit := black.expect("Forfeit")
+    if forfeit.Player != "White" || forfeit.Payload != "Black" {
+        t.Errorf("expect White to forfeit to Black got %s to %s", forfeit.Player, forfeit.Payload)
+    }
+}
+
+func TestLifecycle(t *testing.T) {
+    url := startServer(t)
+    black := dial(t, url)
+    black.send(Request{Action: "New", NPlayers: 2, Payload: smallBoard()})
+    key := black.expect("New").Key
+    game := games.Get(key)
+    state := func() GameState {
+        game.Mutex.Lock()
+        defer game.Mutex.Unlock()
+        return game.State
+    }
+    if state() != Waiting {
+        t.Errorf("expect %s got %s", Waiting, state())
+    }
+    rec := httptest.NewRecorder()
+    AdminGames(rec, httptest.NewRequest("GET", "/admin/games", nil))
+    var counts map[GameState]int
+    json.Unmarshal(rec.Body.Bytes(), &counts)
+    if counts[Waiting] < 1 || len(counts) != len(States) {
+        t.Errorf("bad counts %v", counts)
+    }
+    // Nobody joined before the creator left
+    black.conn.Close()
+    for i := 0; i < 50 && state() != Abandoned; i++ {
+        time.Sleep(20 * time.Millisecond)
+    }
+    if state() != Abandoned {
+        t.Errorf("expect %s got %s", Abandoned, state())
+    }
+    Reap(time.Now())
+    if games.Get(key) == nil {
+        t.Errorf("game %d expired too early", key)
+    }
+    Reap(time.Now().Add(2 * IdleTimeout))
+    if games.Get(key) != nil {
+        t.Errorf("game %d not expired", key)
+    }
+}
+
+func TestExhibition(t *testing.T) {
+    url := startServer(t)
+    host := dial(t, url)
+    host.send(Request{Action: "Exhibition", NPlayers: 2, Payload: smallBoard(), Levels: []string{"test", "test"}, Pace: 100})
+    key := host.expect("Watch").Key
+    host.expect("Move-AI")
+    start := time.Now()
+    host.expect("Move-AI")
+    if time.Since(start) < 80 * time.Millisecond {
+        t.Errorf("moves came faster than the pace")
+    }
+    // Played out and scored without anyone accepting
+    host.expect("Result")
+    game := games.Get(key)
+    game.Mutex.Lock()
+    if game.State != Finished {
+        t.Errorf("expect %s got %s", Finished, game.State)
+    }
+    game.Mutex.Unlock()
+    other := dial(t, url)
+    other.send(Request{Action: "Exhibition", NPlayers: 2, Levels: []string{"test"}})
+    other.expect("Error")
+    other.send(Request{Action: "Exhibition", NPlayers: 2, Levels: []string{"test", "test"}, Board: "../go.mod"})
+    other.expect("Error")
+}
+
+// Fischer clocks go out with every move and white loses on time by not moving
+func TestClocks(t *testing.T) {
+    url := startServer(t)
+    black := dial(t, url)
+    black.send(Request{Action: "New", NPlayers: 2, Payload: smallBoard(), Clock: `{"Kind": "fischer", "Main": 300, "Increment": 5000}`})
+    key := black.expect("New").Key
+    white := dial(t, url)
+    white.send(Request{Action: "Join", Key: key})
+    join := white.expect("Join")
+    if len(join.Clocks) != 2 || join.Clocks[0].Main > 300 {
+        t.Fatalf("expect two clocks got %v", join.Clocks)
+    }
+    black.expect("Join")
+    black.send(Request{Action: "Move", Key: key, Payload: withStone(join.Payload, 4, "black")})
+    move := white.expect("Move")
+    // Black got the increment
+    if move.Clocks[0].Main < 5000 || move.Clocks[1].Main > 300 {
+        t.Errorf("expect black over 5000 and white under 300 got %d and %d", move.Clocks[0].Main, move.Clocks[1].Main)
+    }
+    timeout := black.expect("Timeout")
+    if timeout.Player != "White" || timeout.Payload != "Black" || !timeout.Clocks[1].Flagged {
+        t.Errorf("expect White to lose on time to Black got %s to %s", timeout.Player, timeout.Payload)
+    }
+    // Too late
+    white.send(Request{Action: "Pass", Key: key})
+    white.expect("Error")
+    other := dial(t, url)
+    other.send(Request{Action: "New", NPlayers: 2, Payload: smallBoard(), Clock: `{"Kind": "byoyomi", "Main": 1000}`})
+    other.expect("Error")
+}
+
+// Black takes a move back once white accepts, a decline leaves the position
+func TestUndo(t *testing.T) {
+    url := startServer(t)
+    black, white, key := startGame(t, url)
+    black.send(Request{Action: "UndoRequest", Key: key})
+    black.expect("Error")
+    start := games.Get(key)
+    start.Mutex.Lock()
+    position := start.Json
+    start.Mutex.Unlock()
+    black.send(Request{Action: "Move", Key: key, Payload: withStone(position, 4, "black")})
+    white.expect("Move")
+    black.send(Request{Action: "UndoRequest", Key: key})
+    if req := white.expect("UndoRequest"); req.Player != "Black" {
+        t.Errorf("expect Black to ask got %s", req.Player)
+    }
+    black.send(Request{Action: "UndoAccept", Key: key})
+    black.expect("Error")
+    white.send(Request{Action: "UndoDecline", Key: key})
+    black.expect("UndoDecline")
+    black.send(Request{Action: "UndoRequest", Key: key})
+    white.expect("UndoRequest")
+    white.send(Request{Action: "UndoAccept", Key: key})
+    undo := black.expect("Undo")
+    if undo.Player != "black" || undo.Payload != position {
+        t.Errorf("expect the empty board with black to move got %s", undo.Player)
+    }
+}
+
+// With the once policy the AI allows a single takeback
+func TestAIUndo(t *testing.T) {
+    url := startServer(t)
+    human := dial(t, url)
+    human.send(Request{Action: "New-AI", Level: "test", NPlayers: 2, Payload: smallBoard(), Undo: "once"})
+    reply := human.expect("New")
+    human.send(Request{Action: "Move-AI", Key: reply.Key, Payload: `{"Point": 4}`})
+    human.expect("Move-AI")
+    human.expect("Move-AI")
+    // Our move and the AI's reply are taken back
+    human.send(Request{Action: "UndoRequest", Key: reply.Key})
+    undo := human.expect("Undo")
+    if undo.Player != "black" || undo.Payload != reply.Payload {
+        t.Errorf("expect the empty board with black to move got %s", undo.Player)
+    }
+    human.send(Request{Action: "Move-AI", Key: reply.Key, Payload: `{"Point": 0}`})
+    human.expect("Move-AI")
+    human.send(Request{Action: "UndoRequest", Key: reply.Key})
+    if decline := human.expect("UndoDecline"); decline.Player != "White" {
+        t.Errorf("expect White to decline got %s", decline.Player)
+    }
+    other := dial(t, url)
+    other.send(Request{Action: "New-AI", Level: "test", Payload: smallBoard(), Undo: "sometimes"})
+    other.expect("Error")
+}
+
+// A played game comes back as SGF, over the socket and as a download, and imports again
+func TestRecordAndImport(t *testing.T) {
+    url := startServer(t)
+    black, white, key := startGame(t, url)
+    join := games.Get(key)
+    join.Mutex.Lock()
+    position := join.Json
+    join.Mutex.Unlock()
+    black.send(Request{Action: "Move", Key: key, Payload: withStone(position, 4, "black")})
+    white.expect("Move")
+    white.send(Request{Action: "Pass", Key: key})
+    black.expect("Pass")
+    black.send(Request{Action: "Chat", Key: key, Payload: "gg"})
+    black.expect("Chat")
+    other := dial(t, url)
+    other.send(Request{Action: "Record", Key: key})
+    text := other.expect("Record").Payload
+    if !strings.Contains(text, ";B[4]") || !strings.Contains(text, ";W[]") || !strings.Contains(text, "C[Black: gg]") {
+        t.Errorf("bad record %s", text)
+    }
+    res, err := http.Get("http" + strings.TrimSuffix(strings.TrimPrefix(url, "ws"), "/ws") + "/record?key=" + strconv.Itoa(key))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer res.Body.Close()
+    body, _ := io.ReadAll(res.Body)
+    if string(body) != text || res.Header.Get("Content-Type") != "application/x-go-sgf" {
+        t.Errorf("download differs from the record")
+    }
+    other.send(Request{Action: "Import", Payload: text})
+    imported := other.expect("Import")
+    if imported.Player != "black" || imported.NPlayers != 2 {
+        t.Errorf("expect black to move got %s", imported.Player)
+    }
+    // Onto an occupied point
+    other.send(Request{Action: "Import", Payload: strings.Replace(text, ";W[]", ";W[4]", 1)})
+    other.expect("Error")
+    other.send(Request{Action: "Record", Key: 100000})
+    other.expect("Error")
+}
+
+// Two clients step through a record together, branch off, comment and ask the AI
+func TestReview(t *testing.T) {
+    url := startServer(t)
+    graph, _ := json.Marshal(ai.MakeTraditional(3, 2).Neighbors)
+    text := "(;GM[1]XN[2]XG[" + strings.ReplaceAll(string(graph), "]", "\\]") + "]C[start];B[4];W[0]C[corner])"
+    host := dial(t, url)
+    host.send(Request{Action: "Review", Payload: text})
+    reply := host.expect("Review")
+    var state ReviewState
+    json.Unmarshal([]byte(reply.Payload), &state)
+    if state.Node != 0 || state.Comment != "start" || len(state.Children) != 1 {
+        t.Fatalf("expect the root got %v", state)
+    }
+    guest := dial(t, url)
+    guest.send(Request{Action: "ReviewJoin", Key: reply.Key})
+    guest.expect("Review")
+    host.send(Request{Action: "ReviewForward", Key: reply.Key})
+    host.send(Request{Action: "ReviewForward", Key: reply.Key})
+    host.expect("ReviewState")
+    host.expect("ReviewState")
+    guest.expect("ReviewState")
+    json.Unmarshal([]byte(guest.expect("ReviewState").Payload), &state)
+    if state.Comment != "corner" || state.LastMove != 0 || len(state.Path) != 3 {
+        t.Errorf("expect white's corner move got %v", state)
+    }
+    // A different reply for white
+    guest.send(Request{Action: "ReviewBack", Key: reply.Key})
+    host.expect("ReviewState")
+    guest.expect("ReviewState")
+    guest.send(Request{Action: "ReviewPlay", Key: reply.Key, Payload: `{"Point": 8}`})
+    guest.expect("ReviewState")
+    json.Unmarshal([]byte(host.expect("ReviewState").Payload), &state)
+    if state.LastMove != 8 || state.Parent != 1 {
+        t.Errorf("expect a variation at 8 got %v", state)
+    }
+    guest.send(Request{Action: "ReviewPlay", Key: reply.Key, Payload: `{"Point": 4}`})
+    guest.expect("Error")
+    host.send(Request{Action: "ReviewComment", Key: reply.Key, Payload: "better"})
+    host.expect("ReviewState")
+    guest.expect("ReviewState")
+    // Too strong for a review, then no room left on the server
+    host.send(Request{Action: "ReviewEval", Key: reply.Key, Level: "expert"})
+    host.expect("Error")
+    for i := 0; i < cap(reviewEvals); i++ {
+        reviewEvals <- true
+    }
+    host.send(Request{Action: "ReviewEval", Key: reply.Key, Level: "test"})
+    busy := host.expect("Error").Payload
+    for i := 0; i < cap(reviewEvals); i++ {
+        <-reviewEvals
+    }
+    if busy != "The AI is busy, try again soon" {
+        t.Errorf("expect the AI to be busy got %s", busy)
+    }
+    host.send(Request{Action: "ReviewEval", Key: reply.Key, Level: "test"})
+    var eval Evaluation
+    json.Unmarshal([]byte(guest.expect("ReviewEval").Payload), &eval)
+    if !eval.Moved || eval.Point != 8 || eval.Node != state.Node {
+        t.Errorf("bad evaluation %v", eval)
+    }
+    host.send(Request{Action: "ReviewRecord", Key: reply.Key})
+    record := host.expect("Record").Payload
+    if !strings.Contains(record, "(;W[0]C[corner])") || !strings.Contains(record, "(;W[8]C[better])") {
+        t.Errorf("expect both variations in %s", record)
+    }
+    bad := dial(t, url)
+    bad.send(Request{Action: "Review", Payload: strings.Replace(text, ";W[0]", ";W[4]", 1)})
+    bad.expect("Error")
+    // Black twice is not recolored
+    bad.send(Request{Action: "Review", Payload: strings.Replace(text, ";W[0]", ";B[0]", 1)})
+    if err := bad.expect("Error").Payload; err != "Move 2 is out of turn" {
+        t.Errorf("expect move 2 out of turn got %s", err)
+    }
+}
+
+// A registered bot accepts a challenge and plays with Move-AI, a busy or missing bot is refused
+func TestBot(t *testing.T) {
+    url := startServer(t)
+    bot := dial(t, url)
+    bot.send(Request{Action: "BotRegister", Bot: "testbot"})
+    bot.expect("BotRegister")
+    other := dial(t, url)
+    other.send(Request{Action: "BotRegister", Bot: "testbot"})
+    other.expect("Error")
+    human := dial(t, url)
+    human.send(Request{Action: "Bots"})
+    var list []BotEntry
+    json.Unmarshal([]byte(human.expect("Bots").Payload), &list)
+    found := false
+    for _, entry := range list {
+        found = found || entry.Name == "testbot" && !entry.Busy
+    }
+    if !found {
+        t.Errorf("testbot not listed as free in %v", list)
+    }
+    human.send(Request{Action: "Challenge", Bot: "testbot", NPlayers: 2, Payload: smallBoard()})
+    key := human.expect("New").Key
+    challenge := bot.expect("Challenge")
+    if challenge.Key != key || challenge.Seat != 1 || challenge.NPlayers != 2 {
+        t.Errorf("bad challenge %v", challenge)
+    }
+    // Only one game at a time
+    rival := dial(t, url)
+    rival.send(Request{Action: "Challenge", Bot: "testbot", NPlayers: 2, Payload: smallBoard()})
+    if e := rival.expect("Error"); e.Payload != "testbot is busy" {
+        t.Errorf("expect testbot to be busy got %s", e.Payload)
+    }
+    rival.send(Request{Action: "Challenge", Bot: "nobody", NPlayers: 2, Payload: smallBoard()})
+    rival.expect("Error")
+    bot.send(Request{Action: "BotAccept", Key: key})
+    human.expect("Join")
+    state := bot.expect("BotGame")
+    var bg BotGame
+    json.Unmarshal([]byte(state.Payload), &bg)
+    if state.Seat != 1 || state.Token == "" || len(bg.Neighbors) != 9 || len(bg.Moves) != 0 {
+        t.Errorf("bad bot game %v", state)
+    }
+    human.send(Request{Action: "Move-AI", Key: key, Payload: `{"Point": 4}`})
+    if move := bot.expect("Move-AI"); move.Player != "black" || move.Payload != `{"Point":4}` {
+        t.Errorf("expect black on 4 got %s %s", move.Player, move.Payload)
+    }
+    bot.send(Request{Action: "Move-AI", Key: key, Payload: `{"Point": 0}`})
+    bot.expect("Move-AI")
+    human.expect("Move-AI")
+    if move := human.expect("Move-AI"); move.Player != "white" {
+        t.Errorf("expect the bot to have moved got %s", move.Player)
+    }
+    // Scoring waits for the bot
+    human.send(Request{Action: "Move-AI", Key: key, Payload: `{"Point": -1}`})
+    bot.expect("Move-AI")
+    bot.send(Request{Action: "Move-AI", Key: key, Payload: `{"Point": -1}`})
+    human.expect("Score")
+    human.send(Request{Action: "AcceptScore", Key: key})
+    human.expect("Score")
+    bot.send(Request{Action: "AcceptScore", Key: key})
+    human.expect("Result")
+    record, err := FindRecord(key)
+    if err != nil || record.Players[1] != "testbot" {
+        t.Errorf("expect testbot in the record got %v", record)
+    }
+    // Free again, a decline ends the game
+    rival.send(Request{Action: "Challenge", Bot: "testbot", NPlayers: 2, Payload: smallBoard()})
+    key = rival.expect("New").Key
+    bot.expect("Challenge")
+    bot.send(Request{Action: "BotDecline", Key: key})
+    if declined := rival.expect("Declined"); declined.Bot != "testbot" {
+        t.Errorf("expect testbot to decline got %s", declined.Bot)
+    }
+}
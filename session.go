@@ -0,0 +1,209 @@
+package main
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "log"
+    "sync"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+// Player sessions
+// New, New-AI and Join hand each seat a reconnect token, and a dropped player
+// can Resume their seat with it from a fresh connection
+// A seat left empty for AbandonTimeout forfeits the game
+// Any number of observers can Watch a game without a seat
+
+var AbandonTimeout = 2 * time.Minute
+
+// Game connections are written to from the socket loop, GameLoop and timers
+// and a websocket allows only one writer at a time
+type Conn struct {
+    *websocket.Conn
+    mutex sync.Mutex
+}
+
+func (conn *Conn) WriteMessage(messageType int, data []byte) error {
+    conn.mutex.Lock()
+    defer conn.mutex.Unlock()
+    return conn.Conn.WriteMessage(messageType, data)
+}
+
+type ChatLine struct {
+    Player string
+    Payload string
+}
+
+func NewToken() string {
+    b := make([]byte, 16)
+    _, err := rand.Read(b)
+    if err != nil {
+        log.Println(err)
+    }
+    return hex.EncodeToString(b)
+}
+
+// Seat holding the token, -1 for none
+func (game *Game) TokenSeat(token string) int {
+    if token == "" {
+        return -1
+    }
+    for seat, t := range game.Tokens {
+        if t == token {
+            return seat
+        }
+    }
+    return -1
+}
+
+// Socket for the seat has closed
+func (game *Game) Disconnect(seat int, conn *Conn) {
+    game.Mutex.Lock()
+    defer game.Mutex.Unlock()
+    // Already replaced by a Resume
+    if game.Conns[seat] != conn {
+        return
+    }
+    game.Conns[seat] = nil
+    if game.IsOver() {
+        return
+    }
+    game.Broadcast(Request{Action: "Disconnect", Key: game.Key, Player: Title(colors[seat])})
+    game.StartAbandonTimer(seat)
+}
+
+// Call with the game locked
+func (game *Game) StartAbandonTimer(seat int) {
+    if game.timers == nil {
+        game.timers = make([]*time.Timer, len(game.Conns))
+    }
+    if game.timers[seat] != nil {
+        game.timers[seat].Stop()
+    }
+    game.timers[seat] = time.AfterFunc(AbandonTimeout, func() {
+        game.Mutex.Lock()
+        if game.Conns[seat] != nil || game.IsOver() {
+            game.Mutex.Unlock()
+            return
+        }
+        // Challenge nobody answered
+        if game.State == Waiting && game.IsBot(seat) {
+            game.Decline(seat)
+            game.Mutex.Unlock()
+            return
+        }
+        // Not started yet, give the seat to someone else
+        if game.State == Waiting {
+            game.Tokens[seat] = ""
+            if game.Empty() {
+                game.Abandon()
+            } else {
+                game.Save()
+            }
+            game.Mutex.Unlock()
+            return
+        }
+        game.Mutex.Unlock()
+        log.Println("Game", game.Key, "abandoned by", colors[seat])
+        game.Forfeit(seat, "Forfeit")
+    })
+}
+
+// Call with the game locked
+func (game *Game) StopAbandonTimer(seat int) {
+    if game.timers != nil && game.timers[seat] != nil {
+        game.timers[seat].Stop()
+        game.timers[seat] = nil
+    }
+}
+
+// End the game with player giving up, the best score among everyone else wins
+// Action is Concede, Forfeit or Timeout
+func (game *Game) Forfeit(player int, action string) {
+    game.Mutex.Lock()
+    defer game.Mutex.Unlock()
+    game.forfeit(player, action)
+}
+
+// Call with the game locked
+func (game *Game) forfeit(player int, action string) {
+    if game.IsOver() {
+        return
+    }
+    board := game.History[len(game.History)-1]
+    scores := board.GetFinalScores()
+    winner := -1
+    for i, score := range scores {
+        if i != player && (winner == -1 || score > scores[winner]) {
+            winner = i
+        }
+    }
+    how := " wins by forfeit"
+    switch action {
+        case "Concede":
+            how = " wins by concession"
+        case "Timeout":
+            how = " wins on time"
+    }
+    game.End(Finished, Title(colors[winner]) + how)
+    game.Save()
+    game.Broadcast(Request{Action: action, Key: game.Key, Payload: Title(colors[winner]), Player: Title(colors[player]), Clocks: game.ClockState()})
+}
+
+// Rebind a fresh connection to the seat and replay the position and chat
+// Call with the game locked
+func (game *Game) Resume(seat int, conn *Conn) {
+    if old := game.Conns[seat]; old != nil && old != conn {
+        // Its socket loop sees the seat was taken over and leaves it alone
+        old.Close()
+    }
+    game.Conns[seat] = conn
+    game.StopAbandonTimer(seat)
+    game.SendState(conn, "Resume", seat, game.Tokens[seat])
+    game.Broadcast(Request{Action: "Reconnect", Key: game.Key, Player: Title(colors[seat])})
+}
+
+// Attach a read-only observer, it gets the position, chat and every broadcast
+// Call with the game locked
+func (game *Game) Watch(conn *Conn) {
+    game.Watchers = append(game.Watchers, conn)
+    game.SendState(conn, "Watch", -1, "")
+}
+
+func (game *Game) Unwatch(conn *Conn) {
+    game.Mutex.Lock()
+    defer game.Mutex.Unlock()
+    for i, c := range game.Watchers {
+        if c == conn {
+            game.Watchers = append(game.Watchers[:i], game.Watchers[i+1:]...)
+            return
+        }
+    }
+}
+
+// Position, chat so far and the scoring state for a connection arriving mid game
+// Seat is -1 for observers
+func (game *Game) SendState(conn *Conn, action string, seat int, token string) {
+    board := game.History[len(game.History)-1]
+    rules, _ := json.Marshal(board.GetRules())
+    reply := Request{Action: action, Key: game.Key, Payload: game.Json, BoardPlan: game.BoardPlan, Player: game.Player, Rules: string(rules), NPlayers: board.NPlayers, Seat: seat, Token: token, Clocks: game.ClockState()}
+    jsn, _ := json.Marshal(reply)
+    conn.WriteMessage(websocket.TextMessage, jsn)
+    for _, line := range game.Chat {
+        jsn, _ := json.Marshal(Request{Action: "Chat", Key: game.Key, Player: line.Player, Payload: line.Payload})
+        conn.WriteMessage(websocket.TextMessage, jsn)
+    }
+    if undo := game.PendingUndo; undo != nil && undo.Moves == len(game.History) {
+        jsn, _ := json.Marshal(Request{Action: "UndoRequest", Key: game.Key, Player: Title(colors[undo.Player])})
+        conn.WriteMessage(websocket.TextMessage, jsn)
+    }
+    if game.State == Scoring {
+        state := game.ScoreState()
+        jsn, _ := json.Marshal(state)
+        jsn, _ = json.Marshal(Request{Action: "Score", Key: game.Key, Payload: string(jsn)})
+        conn.WriteMessage(websocket.TextMessage, jsn)
+    }
+}
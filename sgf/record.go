@@ -0,0 +1,376 @@
+package sgf
+
+import (
+    "encoding/json"
+    "errors"
+    "strconv"
+    "strings"
+
+    ai "github.com/aorliche/web-nongrid-go/ai"
+)
+
+// Game records for graph boards
+// Standard properties where SGF has them: GM, FF, PB/PW, KM, RE, C, AB/AW, PL and B/W moves
+// Custom ones for everything a grid doesn't need:
+//   XN  number of players
+//   XG  neighbor graph, JSON array of neighbor lists
+//   XP  board plan the graph was built from, for drawing it
+//   XK, XC, XS  ko rule, scoring and suicide
+// Red and blue use XR/XL moves, XAR/XAL setup and XPR/XPL names
+// Moves are point indices, an empty value is a pass
+// Ordinary SGF files without XG are read as square grids of size SZ
+// with the usual letter coordinates
+
+var MoveProps = []string{"B", "W", "XR", "XL"}
+var SetupProps = []string{"AB", "AW", "XAR", "XAL"}
+var NameProps = []string{"PB", "PW", "XPR", "XPL"}
+
+type Move struct {
+    Player int
+    // -1 for a pass
+    Point int
+    Comment string
+}
+
+type Record struct {
+    NPlayers int
+    Neighbors [][]int
+    BoardPlan string
+    // Stones on the board before the first move, -1 for empty, nil for none
+    Start []int
+    // Seat to move first
+    StartTurn int
+    Rules *ai.Rules
+    // Name of each seat
+    Players []string
+    Moves []Move
+    Result string
+    Comment string
+}
+
+// Record of a game played out in history
+func FromHistory(history []*ai.Board) *Record {
+    first := history[0]
+    record := &Record{
+        NPlayers: first.NPlayers,
+        Neighbors: first.Neighbors,
+        StartTurn: first.Turn % first.NPlayers,
+        Rules: first.GetRules(),
+        Players: make([]string, first.NPlayers),
+        Moves: make([]Move, 0, len(history)-1),
+    }
+    for _, player := range first.Points {
+        if player != -1 {
+            record.Start = first.Points
+            break
+        }
+    }
+    for i := 1; i < len(history); i++ {
+        prev := history[i-1]
+        record.Moves = append(record.Moves, Move{Player: prev.Turn % prev.NPlayers, Point: ai.LastMove(history[:i], history[i])})
+    }
+    return record
+}
+
+// The first position of the record
+func (record *Record) Board() (*ai.Board, error) {
+    if record.NPlayers < 2 || record.NPlayers > len(MoveProps) {
+        return nil, errors.New("Records need 2 to " + strconv.Itoa(len(MoveProps)) + " players")
+    }
+    npts := len(record.Neighbors)
+    points := make([]int, npts)
+    for i := range points {
+        points[i] = -1
+    }
+    if record.Start != nil {
+        if len(record.Start) != npts {
+            return nil, errors.New("Setup doesn't match the board")
+        }
+        copy(points, record.Start)
+    }
+    err := ai.CheckGraph(points, record.Neighbors, record.NPlayers)
+    if err != nil {
+        return nil, err
+    }
+    board := &ai.Board{
+        Points: points,
+        Neighbors: record.Neighbors,
+        NPlayers: record.NPlayers,
+        Turn: record.StartTurn,
+        Rules: record.Rules,
+    }
+    board.Rehash()
+    return board, nil
+}
+
+// Play the moves from the first position, every one checked against the rules
+func (record *Record) Replay() ([]*ai.Board, error) {
+    board, err := record.Board()
+    if err != nil {
+        return nil, err
+    }
+    history := []*ai.Board{board}
+    for i, move := range record.Moves {
+        if move.Player != board.Turn % board.NPlayers {
+            return nil, errors.New("Move " + strconv.Itoa(i+1) + " is out of turn")
+        }
+        if move.Point < -1 || move.Point >= len(board.Points) {
+            return nil, errors.New("Move " + strconv.Itoa(i+1) + " is off the board")
+        }
+        next, err := board.Move(history, move.Point, move.Player)
+        if err != nil {
+            return nil, errors.New("Move " + strconv.Itoa(i+1) + ": " + err.Error())
+        }
+        history = append(history, next)
+        board = next
+    }
+    return history, nil
+}
+
+// Root properties of the record without any moves
+func (record *Record) Root() *Node {
+    root := NewNode()
+    root.Set("GM", "1")
+    root.Set("FF", "4")
+    root.Set("CA", "UTF-8")
+    root.Set("AP", "web-nongrid-go")
+    root.Set("XN", strconv.Itoa(record.NPlayers))
+    graph, _ := json.Marshal(record.Neighbors)
+    root.Set("XG", string(graph))
+    if record.BoardPlan != "" {
+        root.Set("XP", record.BoardPlan)
+    }
+    for i, name := range record.Players {
+        if name != "" {
+            root.Set(NameProps[i], name)
+        }
+    }
+    rules := record.Rules
+    if rules == nil {
+        rules = &ai.DefaultRules
+    }
+    root.Set("KM", strconv.FormatFloat(rules.Komi, 'f', -1, 64))
+    root.Set("XK", string(rules.Ko))
+    root.Set("XC", string(rules.Scoring))
+    if rules.Suicide {
+        root.Set("XS", "1")
+    }
+    for p, player := range record.Start {
+        if player != -1 {
+            root.Set(SetupProps[player], append(root.Props[SetupProps[player]], strconv.Itoa(p))...)
+        }
+    }
+    if record.StartTurn != 0 {
+        root.Set("PL", MoveProps[record.StartTurn])
+    }
+    if record.Result != "" {
+        root.Set("RE", record.Result)
+    }
+    if record.Comment != "" {
+        root.Set("C", record.Comment)
+    }
+    return root
+}
+
+// Node for a move
+func (move Move) Node() *Node {
+    node := NewNode()
+    if move.Point == -1 {
+        node.Set(MoveProps[move.Player], "")
+    } else {
+        node.Set(MoveProps[move.Player], strconv.Itoa(move.Point))
+    }
+    if move.Comment != "" {
+        node.Set("C", move.Comment)
+    }
+    return node
+}
+
+// The record as a tree with the moves as its main line
+func (record *Record) Tree() *Node {
+    root := record.Root()
+    node := root
+    for _, move := range record.Moves {
+        node = node.AddChild(move.Node())
+    }
+    return root
+}
+
+func (record *Record) String() string {
+    return record.Tree().String()
+}
+
+// Everything but the moves from the root node
+func FromRoot(root *Node) (*Record, error) {
+    record := &Record{NPlayers: 2}
+    var err error
+    if root.Has("GM") && root.Get("GM") != "1" {
+        return nil, errors.New("Not a game of Go")
+    }
+    if root.Has("XN") {
+        record.NPlayers, err = strconv.Atoi(root.Get("XN"))
+        if err != nil || record.NPlayers < 2 || record.NPlayers > len(MoveProps) {
+            return nil, errors.New("Bad number of players")
+        }
+    }
+    if root.Has("XG") {
+        err = json.Unmarshal([]byte(root.Get("XG")), &record.Neighbors)
+        if err != nil {
+            return nil, errors.New("Bad neighbor graph")
+        }
+    } else {
+        size := 19
+        if root.Has("SZ") {
+            size, err = strconv.Atoi(root.Get("SZ"))
+            if err != nil || size < 1 || size > 52 {
+                return nil, errors.New("Only square boards are supported without a graph")
+            }
+        }
+        record.Neighbors = ai.MakeTraditional(size, record.NPlayers).Neighbors
+    }
+    record.BoardPlan = root.Get("XP")
+    record.Players = make([]string, record.NPlayers)
+    for i := range record.Players {
+        record.Players[i] = root.Get(NameProps[i])
+    }
+    rules := ai.DefaultRules
+    if root.Has("KM") {
+        rules.Komi, err = strconv.ParseFloat(root.Get("KM"), 64)
+        if err != nil {
+            return nil, errors.New("Bad komi")
+        }
+    }
+    if root.Has("XK") {
+        rules.Ko = ai.KoRule(root.Get("XK"))
+    }
+    if root.Has("XC") {
+        rules.Scoring = ai.Scoring(root.Get("XC"))
+    } else if ru := strings.ToLower(root.Get("RU")); ru == "japanese" || ru == "korean" {
+        rules.Scoring = ai.TerritoryScoring
+    }
+    rules.Suicide = root.Get("XS") == "1"
+    // Same checks as rules from a browser
+    jsn, _ := json.Marshal(rules)
+    record.Rules, err = ai.ParseRules(string(jsn))
+    if err != nil {
+        return nil, err
+    }
+    for player, ident := range SetupProps[:record.NPlayers] {
+        for _, val := range root.Props[ident] {
+            p, err := record.Point(val)
+            if err != nil || p == -1 {
+                return nil, errors.New("Bad setup stone " + val)
+            }
+            if record.Start == nil {
+                record.Start = make([]int, len(record.Neighbors))
+                for i := range record.Start {
+                    record.Start[i] = -1
+                }
+            }
+            record.Start[p] = player
+        }
+    }
+    if root.Has("PL") {
+        record.StartTurn = record.Seat(root.Get("PL"))
+        if record.StartTurn == -1 {
+            return nil, errors.New("Bad player to move " + root.Get("PL"))
+        }
+    }
+    record.Result = root.Get("RE")
+    record.Comment = root.Get("C")
+    return record, nil
+}
+
+// Seat for a move property, -1 for none
+func (record *Record) Seat(ident string) int {
+    for i, prop := range MoveProps[:record.NPlayers] {
+        if prop == ident {
+            return i
+        }
+    }
+    return -1
+}
+
+// Point for a move value, -1 for a pass
+// Values are point indices, or letter coordinates for grids without a graph
+func (record *Record) Point(val string) (int, error) {
+    npts := len(record.Neighbors)
+    if val == "" {
+        return -1, nil
+    }
+    if p, err := strconv.Atoi(val); err == nil {
+        if p < 0 || p >= npts {
+            return 0, errors.New("Point " + val + " is off the board")
+        }
+        return p, nil
+    }
+    size := 1
+    for size * size < npts {
+        size += 1
+    }
+    if len(val) != 2 || size * size != npts {
+        return 0, errors.New("Bad point " + val)
+    }
+    coord := func(c byte) int {
+        switch {
+            case c >= 'a' && c <= 'z':
+                return int(c - 'a')
+            case c >= 'A' && c <= 'Z':
+                return int(c - 'A') + 26
+        }
+        return -1
+    }
+    c, r := coord(val[0]), coord(val[1])
+    // tt was a pass before FF[4]
+    if c == 19 && r == 19 && size <= 19 {
+        return -1, nil
+    }
+    if c < 0 || r < 0 || c >= size || r >= size {
+        return 0, errors.New("Point " + val + " is off the board")
+    }
+    return r * size + c, nil
+}
+
+// Move in a node, ok is false for nodes without one
+func (record *Record) NodeMove(node *Node) (Move, bool, error) {
+    for _, ident := range node.Keys {
+        seat := record.Seat(ident)
+        if seat == -1 {
+            continue
+        }
+        p, err := record.Point(node.Get(ident))
+        if err != nil {
+            return Move{}, false, err
+        }
+        return Move{Player: seat, Point: p, Comment: node.Get("C")}, true, nil
+    }
+    return Move{}, false, nil
+}
+
+// A record from its tree, following the main line
+func FromTree(root *Node) (*Record, error) {
+    record, err := FromRoot(root)
+    if err != nil {
+        return nil, err
+    }
+    for node := root; len(node.Children) > 0; {
+        node = node.Children[0]
+        move, ok, err := record.NodeMove(node)
+        if err != nil {
+            return nil, err
+        }
+        if ok {
+            record.Moves = append(record.Moves, move)
+        }
+    }
+    return record, nil
+}
+
+// Read a record written by String or another SGF program
+func Decode(text string) (*Record, error) {
+    root, err := Parse(text)
+    if err != nil {
+        return nil, err
+    }
+    return FromTree(root)
+}
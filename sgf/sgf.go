@@ -0,0 +1,217 @@
+package sgf
+
+import (
+    "errors"
+    "strconv"
+    "strings"
+)
+
+// Smart Game Format trees
+// Only the syntax lives here, record.go maps game records onto it
+// Property values are kept unescaped, escaping happens when writing
+
+type Node struct {
+    Props map[string][]string
+    // Property order, so records are written back the way they were read
+    Keys []string
+    // The first child is the main line, the rest are variations
+    Children []*Node
+}
+
+func NewNode() *Node {
+    return &Node{Props: make(map[string][]string)}
+}
+
+// First value of the property, empty if missing
+func (node *Node) Get(ident string) string {
+    if vals := node.Props[ident]; len(vals) > 0 {
+        return vals[0]
+    }
+    return ""
+}
+
+func (node *Node) Has(ident string) bool {
+    _, ok := node.Props[ident]
+    return ok
+}
+
+// Replace the values of the property
+func (node *Node) Set(ident string, vals ...string) {
+    if !node.Has(ident) {
+        node.Keys = append(node.Keys, ident)
+    }
+    node.Props[ident] = vals
+}
+
+func (node *Node) AddChild(child *Node) *Node {
+    node.Children = append(node.Children, child)
+    return child
+}
+
+type parser struct {
+    text string
+    pos int
+}
+
+func (p *parser) skipSpace() {
+    for p.pos < len(p.text) && strings.ContainsRune(" \t\r\n", rune(p.text[p.pos])) {
+        p.pos += 1
+    }
+}
+
+func (p *parser) peek() byte {
+    p.skipSpace()
+    if p.pos >= len(p.text) {
+        return 0
+    }
+    return p.text[p.pos]
+}
+
+func (p *parser) fail(msg string) error {
+    return errors.New("SGF: " + msg + " at " + strconv.Itoa(p.pos))
+}
+
+// Parse the first game tree in text
+func Parse(text string) (*Node, error) {
+    p := &parser{text: text}
+    if p.peek() != '(' {
+        return nil, p.fail("expected (")
+    }
+    return p.tree()
+}
+
+// ( sequence of nodes, then variations )
+func (p *parser) tree() (*Node, error) {
+    p.pos += 1
+    var root, last *Node
+    for p.peek() == ';' {
+        p.pos += 1
+        node, err := p.node()
+        if err != nil {
+            return nil, err
+        }
+        if root == nil {
+            root = node
+        } else {
+            last.AddChild(node)
+        }
+        last = node
+    }
+    if root == nil {
+        return nil, p.fail("empty game tree")
+    }
+    for p.peek() == '(' {
+        child, err := p.tree()
+        if err != nil {
+            return nil, err
+        }
+        last.AddChild(child)
+    }
+    if p.peek() != ')' {
+        return nil, p.fail("expected )")
+    }
+    p.pos += 1
+    return root, nil
+}
+
+func (p *parser) node() (*Node, error) {
+    node := NewNode()
+    for {
+        c := p.peek()
+        if c < 'A' || c > 'Z' {
+            // Old files have lowercase letters in identifiers, they are ignored
+            if c >= 'a' && c <= 'z' {
+                p.pos += 1
+                continue
+            }
+            return node, nil
+        }
+        ident := ""
+        for p.pos < len(p.text) && (p.text[p.pos] >= 'A' && p.text[p.pos] <= 'Z' || p.text[p.pos] >= 'a' && p.text[p.pos] <= 'z') {
+            if p.text[p.pos] <= 'Z' {
+                ident += string(p.text[p.pos])
+            }
+            p.pos += 1
+        }
+        vals := make([]string, 0, 1)
+        for p.peek() == '[' {
+            val, err := p.value()
+            if err != nil {
+                return nil, err
+            }
+            vals = append(vals, val)
+        }
+        if len(vals) == 0 {
+            return nil, p.fail("property " + ident + " without a value")
+        }
+        node.Set(ident, append(node.Props[ident], vals...)...)
+    }
+}
+
+// [ value ] with \ escaping the next character and escaped line breaks removed
+func (p *parser) value() (string, error) {
+    p.pos += 1
+    var b strings.Builder
+    for p.pos < len(p.text) {
+        c := p.text[p.pos]
+        p.pos += 1
+        switch c {
+            case ']':
+                return b.String(), nil
+            case '\\':
+                if p.pos >= len(p.text) {
+                    break
+                }
+                next := p.text[p.pos]
+                p.pos += 1
+                if next == '\n' || next == '\r' {
+                    // Soft line break, eat the other half of \r\n too
+                    if p.pos < len(p.text) && (p.text[p.pos] == '\n' || p.text[p.pos] == '\r') && p.text[p.pos] != next {
+                        p.pos += 1
+                    }
+                    continue
+                }
+                b.WriteByte(next)
+            default:
+                b.WriteByte(c)
+        }
+    }
+    return "", p.fail("unterminated value")
+}
+
+func escape(val string) string {
+    val = strings.ReplaceAll(val, "\\", "\\\\")
+    return strings.ReplaceAll(val, "]", "\\]")
+}
+
+// The tree as SGF, one node per line
+func (node *Node) String() string {
+    var b strings.Builder
+    node.write(&b)
+    b.WriteString("\n")
+    return b.String()
+}
+
+func (node *Node) write(b *strings.Builder) {
+    b.WriteString("(")
+    n := node
+    for {
+        b.WriteString(";")
+        for _, ident := range n.Keys {
+            b.WriteString(ident)
+            for _, val := range n.Props[ident] {
+                b.WriteString("[" + escape(val) + "]")
+            }
+        }
+        if len(n.Children) != 1 {
+            break
+        }
+        b.WriteString("\n")
+        n = n.Children[0]
+    }
+    for _, child := range n.Children {
+        b.WriteString("\n")
+        child.write(b)
+    }
+    b.WriteString(")")
+}
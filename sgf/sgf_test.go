@@ -0,0 +1,108 @@
+package sgf
+
+import (
+    "strings"
+    "testing"
+
+    ai "github.com/aorliche/web-nongrid-go/ai"
+)
+
+func TestParse(t *testing.T) {
+    root, err := Parse("(;GM[1]C[a \\] bracket\\\nand a soft break](;B[1];W[])(;B[2]))")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if root.Get("C") != "a ] bracketand a soft break" {
+        t.Errorf("bad comment %q", root.Get("C"))
+    }
+    if len(root.Children) != 2 || root.Children[0].Get("B") != "1" || len(root.Children[0].Children) != 1 {
+        t.Errorf("expected two variations got %v", root.Children)
+    }
+    again, err := Parse(root.String())
+    if err != nil || again.String() != root.String() {
+        t.Errorf("round trip changed the tree: %s", root.String())
+    }
+    for _, bad := range []string{"", "(", "(;B[1]", "(;B)"} {
+        if _, err := Parse(bad); err == nil {
+            t.Errorf("expected error for %q", bad)
+        }
+    }
+}
+
+// Three players with a setup stone, passes, names and rules survive a round trip
+func TestRecord(t *testing.T) {
+    board := ai.MakeTraditional(3, 3)
+    board.Rules = &ai.Rules{Ko: ai.SimpleKo, Scoring: ai.TerritoryScoring, Komi: 1.5}
+    board.Set(4, 2)
+    board.Turn = 1
+    history := []*ai.Board{board}
+    for _, p := range []int{0, -1, 8} {
+        next, err := board.Move(history, p, board.Turn % 3)
+        if err != nil {
+            t.Fatal(err)
+        }
+        history = append(history, next)
+        board = next
+    }
+    record := FromHistory(history)
+    record.Players = []string{"Black", "hard", "Red"}
+    record.Result = "Red wins"
+    text := record.String()
+    if !strings.Contains(text, "XAR[4]") || !strings.Contains(text, "PL[W]") || !strings.Contains(text, "XR[]") {
+        t.Errorf("missing setup or pass in %s", text)
+    }
+    decoded, err := Decode(text)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if decoded.String() != text {
+        t.Errorf("round trip changed the record:\n%s\n%s", text, decoded.String())
+    }
+    replayed, err := decoded.Replay()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(replayed) != len(history) || replayed[3].Hash != history[3].Hash || replayed[0].GetRules().Komi != 1.5 {
+        t.Errorf("replay differs from the game")
+    }
+}
+
+func TestReplayChecksMoves(t *testing.T) {
+    // Out of turn, off the board and onto a stone
+    for _, moves := range []string{";W[1]", ";B[9]", ";B[1];W[1]"} {
+        record, err := Decode("(;GM[1]XN[2]XG[[[1,3],[0,2,4],[1,5],[0,4,6],[1,3,5,7],[2,4,8],[3,7],[4,6,8],[5,7]]]" + moves + ")")
+        if err == nil {
+            _, err = record.Replay()
+        }
+        if err == nil {
+            t.Errorf("expected error for %s", moves)
+        }
+    }
+    // Point 1 doesn't list 0 back
+    record, err := Decode("(;GM[1]XN[2]XG[[[1],[]]])")
+    if err == nil {
+        _, err = record.Replay()
+    }
+    if err == nil {
+        t.Errorf("expected error for a one way graph")
+    }
+}
+
+// SGF from other programs uses letter coordinates on a square grid
+func TestGridRecord(t *testing.T) {
+    record, err := Decode("(;GM[1]FF[4]SZ[9]KM[6.5]RU[Japanese]AB[cc]PL[W];W[ee];B[tt])")
+    if err != nil {
+        t.Fatal(err)
+    }
+    history, err := record.Replay()
+    if err != nil {
+        t.Fatal(err)
+    }
+    last := history[len(history)-1]
+    if len(last.Points) != 81 || last.Points[20] != 0 || last.Points[40] != 1 || record.Moves[1].Point != -1 {
+        t.Errorf("bad grid replay %v", record.Moves)
+    }
+    if record.Rules.Scoring != ai.TerritoryScoring || record.Rules.Komi != 6.5 {
+        t.Errorf("bad rules %v", record.Rules)
+    }
+}
@@ -1,9 +1,19 @@
 
-export {noFillFn, neverFillFn, Board};
+export {noFillFn, neverFillFn, Board, COLORS};
 
 import {approx, dist, fillCircle, strokeCircle} from './util.js';
 import {EDGE_LEN, Point, Edge, Polygon, polyDistFromN, randomEdgePoint, thetaFromN} from './primitives.js';
 
+// In seat order, same as the server
+const COLORS = ['black', 'white', 'red', 'blue'];
+
+function drawStone(ctx, p, rad, color) {
+    fillCircle(ctx, p, rad, color);
+    if (color != 'black') {
+        strokeCircle(ctx, p, rad, 'black');
+    }
+}
+
 function arrayContainsPoly(arr, p) {
     for (let i=0; i<arr.length; i++) {
         if (arr[i].id == p.id) {
@@ -96,6 +106,9 @@ class Board {
         this.polys = [];
         this.points = [];
         this.player = 'black';
+        this.nplayers = 2;
+        // Stones marked dead at the end of the game
+        this.dead = [];
         // Points that are never filled/placed on
         this.nofillpts = [];
     }
@@ -321,22 +334,15 @@ class Board {
         }
         this.points.forEach(p => {
             if (p.player) {
-                if (p.player === 'black') {
-                    fillCircle(this.ctx, p, RAD, 'black');
-                } else if (p.player === 'white') {
-                    fillCircle(this.ctx, p, RAD, 'white');
-                    strokeCircle(this.ctx, p, RAD, 'black');
-                }
+                drawStone(this.ctx, p, RAD, p.player);
             } else if (p.hover) {
-                if (this.player == 'black') {
-                    fillCircle(this.ctx, p, RAD, 'black');
-                } else {
-                    fillCircle(this.ctx, p, RAD, 'white');
-                    strokeCircle(this.ctx, p, RAD, 'black');
-                }
+                drawStone(this.ctx, p, RAD, this.player);
             }
 
         });
+        this.dead.forEach(id => {
+            fillCircle(this.ctx, this.points[id], RAD/2, 'red');
+        });
         if (this.lastId || this.lastId === 0) {
             strokeCircle(this.ctx, this.points[this.lastId], RAD, 'red', 2);
         }
@@ -367,6 +373,17 @@ class Board {
         });
     }
     
+    // Point under the mouse
+    pointAt(x, y) {
+        const cp = new Point(x, y);
+        for (let i=0; i<this.points.length; i++) {
+            if (dist(this.points[i], cp) < EDGE_LEN/2) {
+                return this.points[i];
+            }
+        }
+        return null;
+    }
+    
     click(x, y) {
         const cp = new Point(x, y);
         let good = false;
@@ -378,12 +395,16 @@ class Board {
             const h = d < EDGE_LEN/2;
             if (h) {
                 const sav = this.savePoints();
-                p.player = this.player;
-                this.player = this.player == 'black' ? 'white' : 'black';
-                this.cullCaptured(this.player);
-                this.cullCaptured(this.player == 'black' ? 'white' : 'black');
+                const me = this.player;
+                p.player = me;
+                // Opponents first, then suicide
+                for (let i=1; i<this.nplayers; i++) {
+                    this.cullCaptured(this.nextPlayer(me, i));
+                }
+                this.cullCaptured(me);
+                this.player = this.nextPlayer(me);
                 if (this.pointsInHistory(this.savePoints())) {
-                    this.player = this.player == 'black' ? 'white' : 'black';
+                    this.player = me;
                     this.loadPoints(sav);
                     return;
                 }
@@ -394,6 +415,12 @@ class Board {
         return good;
     }
 
+    // Player n turns after player
+    nextPlayer(player, n) {
+        const i = COLORS.indexOf(player);
+        return COLORS[(i + (n === undefined ? 1 : n)) % this.nplayers];
+    }
+
     pointsInHistory(ps) {
         ps = JSON.stringify(ps);
         for (let i=0; i<this.history.length; i++) {
@@ -540,8 +567,8 @@ class Board {
             }
             return [contested, player, region.size];
         }
-        let bscore = 0;
-        let wscore = 0;
+        // One score for each player in seat order
+        const scores = new Array(this.nplayers).fill(0);
         // Check for no moves
         let move = false;
         for (let i=0; i<this.points.length; i++) {
@@ -550,26 +577,19 @@ class Board {
                 break;
             }
         }
-        //console.log(move, bscore, wscore);
         if (!move) {
-            return [bscore, wscore];
+            return scores;
         }
         this.points.forEach(p => {
             if (!visited.has(p.id) && !p.player) {
                 const [contested, player, size] = expandGetEmptyScore(p.id, this.neighbors, this.id2point);
-                if (!contested) {
-                    if (player == 'black') {
-                        bscore += size;
-                    } else {
-                        wscore += size;
-                    }
+                if (!contested && player) {
+                    scores[COLORS.indexOf(player)] += size;
                 }
-            } else if (p.player == 'black') {
-                bscore += 1;
-            } else if (p.player == 'white') {
-                wscore += 1;
+            } else if (p.player) {
+                scores[COLORS.indexOf(p.player)] += 1;
             }
         });
-        return [bscore, wscore];
+        return scores;
     }
 }
@@ -1,5 +1,5 @@
 import {$, $$, drawText} from './util.js';
-import {noFillFn, neverFillFn, Board} from './board.js';
+import {noFillFn, neverFillFn, Board, COLORS} from './board.js';
 import {Point, Edge, Polygon, randomEdgePoint} from './primitives.js';
 
 let boardjson = null;
@@ -56,70 +56,258 @@ function getLastMove(hist, cur) {
     return null;
 }
 
+function title(color) {
+    return color.charAt(0).toUpperCase() + color.slice(1);
+}
+
+function showScores(game) {
+    const scores = game.board.getScores();
+    $('#scores').innerHTML = scores.map((s, i) => `${title(COLORS[i])}: ${s}`).join('<br>');
+}
+
+// Clocks as the server last sent them, counted down locally for the player to move
+function setClocks(game, clocks) {
+    game.clocks = clocks;
+    game.clocksAt = Date.now();
+    showClocks(game);
+}
+
+function formatTime(ms) {
+    const s = Math.max(0, Math.ceil(ms/1000));
+    return `${Math.floor(s/60)}:${String(s%60).padStart(2, '0')}`;
+}
+
+function showClocks(game) {
+    if (!game.clocks) {
+        $('#clocks').innerHTML = '';
+        return;
+    }
+    const running = !game.over && !game.scoring && game.passes < game.board.nplayers;
+    const elapsed = Date.now() - game.clocksAt;
+    $('#clocks').innerHTML = game.clocks.map((c, i) => {
+        let main = c.Main;
+        let period = c.Period;
+        if (running && COLORS[i] == game.board.player) {
+            main -= elapsed;
+            if (main < 0) {
+                period += main;
+                main = 0;
+            }
+        }
+        let text = formatTime(main);
+        if (main <= 0 && c.Control.Kind == 'byoyomi') {
+            text = `${c.Periods} x ${formatTime(c.Control.Period)}`;
+        } else if (main <= 0 && c.Control.Kind == 'canadian') {
+            text = `${formatTime(period)} / ${c.Stones}`;
+        }
+        return `${title(COLORS[i])}: ${c.Flagged ? 'flagged' : text}`;
+    }).join('<br>');
+}
+
+function pointName(p) {
+    return p == -1 ? 'pass' : `${p}`;
+}
+
+// Variations, comment and AI evaluation of the review node
+function showReview(game) {
+    const state = game.review;
+    const sel = $('#variations');
+    sel.innerHTML = '';
+    state.Children.forEach((c, i) => {
+        const opt = document.createElement('option');
+        opt.value = i;
+        opt.innerText = `${title(c.Player)} ${pointName(c.Point)}${i == 0 ? '' : ' (variation)'}`;
+        sel.appendChild(opt);
+    });
+    let text = `Move ${state.Path.length-1}`;
+    if (state.Comment) {
+        text += `: ${state.Comment}`;
+    }
+    const ev = state.Eval;
+    if (ev) {
+        if (ev.Moved) {
+            text += `<br>Played ${pointName(ev.Point)} (${ev.Value.toFixed(2)}), ${ev.Level} prefers ${pointName(ev.Best)} (${ev.BestValue.toFixed(2)})`;
+        }
+        text += `<br>${title(ev.Level)} would play ${pointName(ev.Next)}`;
+    }
+    $('#review-text').innerHTML = text;
+}
+
+function tokenKey(id) {
+    return `token-${id}`;
+}
+
+// Games we hold a seat in from an earlier page
+function savedTokens() {
+    const ids = [];
+    for (let i=0; i<localStorage.length; i++) {
+        const key = localStorage.key(i);
+        if (key.startsWith('token-')) {
+            ids.push(parseInt(key.slice(6)));
+        }
+    }
+    return ids;
+}
+
+// Take our seat back over a fresh connection
+function resume(game) {
+    game.conn = new WebSocket(`ws://${location.host}/ws`);
+    game.conn.onopen = () => {
+        game.conn.send(JSON.stringify({Action: 'Resume', Key: game.id, Token: localStorage.getItem(tokenKey(game.id))}));
+    };
+    setupListeners(game);
+}
+
+function endGame(game) {
+    game.over = true;
+    localStorage.removeItem(tokenKey(game.id));
+}
+
 function setupListeners(game) {
+    game.conn.onclose = () => {
+        if (game.over || game.left || !localStorage.getItem(tokenKey(game.id))) return;
+        $('#chat').value += `Connection lost, reconnecting...\n`;
+        setTimeout(() => resume(game), 1000);
+    };
     game.conn.onmessage = e => {
         const json = JSON.parse(e.data);
+        if (json.Token) {
+            localStorage.setItem(tokenKey(json.Key), json.Token);
+        }
+        if (json.Clocks) {
+            setClocks(game, json.Clocks);
+        }
         if (json.Action == "New-AI") {
             console.log(json);
             game.id = json.Key;
             $('#vertices').innerText = game.board.points.length;
-            $('#black').innerText = '';
-            $('#white').innerText = '';
+            $('#scores').innerHTML = '';
             return;
         }
         if (json.Action == "New") {
             game.id = json.Key;
+            game.board.nplayers = json.NPlayers;
+            game.player = COLORS[json.Seat];
             $('#vertices').innerText = game.board.points.length;
-            $('#black').innerText = '';
-            $('#white').innerText = '';
+            $('#scores').innerHTML = '';
+            // Computer games start from the server's position, which may have handicap stones
+            if (json.Payload) {
+                const pts = JSON.parse(json.Payload);
+                game.board.history.push(JSON.stringify(pts));
+                game.board.loadPoints(pts);
+                game.board.player = json.Player;
+                game.board.repaint();
+                showScores(game);
+            }
             return;
         }
-        if (json.Action == "Join" || json.Action == "Move") {
+        if (json.Action == "Review" || json.Action == "ReviewState") {
+            // Shared position of a review session, then drawn like any other
+            const state = JSON.parse(json.Payload);
+            game.review = state;
+            game.id = json.Key;
+            json.Payload = state.Position;
+            showReview(game);
+            if (json.Action == "Review") {
+                $('#chat').value += `Reviewing in session ${json.Key}\n`;
+            }
+        }
+        if (json.Action == "ReviewEval") {
+            const ev = JSON.parse(json.Payload);
+            if (game.review && game.review.Node == ev.Node) {
+                game.review.Eval = ev;
+                showReview(game);
+            }
+            return;
+        }
+        if (json.Action == "Record") {
+            // Review with its variations, saved as a file
+            const a = document.createElement('a');
+            a.href = URL.createObjectURL(new Blob([json.Payload], {type: 'application/x-go-sgf'}));
+            a.download = `review-${json.Key}.sgf`;
+            a.click();
+            return;
+        }
+        if (json.Action == "Undo") {
+            $('#chat').value += `Moves taken back, ${title(json.Player)} to play\n`;
+            $('#chat').scrollTop = $('#chat').scrollHeight;
+            // Fall through to case below
+        }
+        if (json.Action == "Join" || json.Action == "Resume" || json.Action == "Watch" || json.Action == "Move" || json.Action == "Undo" || json.Action == "Import" || json.Action == "Review" || json.Action == "ReviewState") {
             // Regenerate board from boardplan if needed
-            if (json.Action == "Join") {
+            if (json.Action == "Join" || json.Action == "Resume" || json.Action == "Watch" || json.Action == "Import" || json.Action == "Review") {
                 if (json.BoardPlan) {
                     boardjson = json.BoardPlan;
                 } else {
                     boardjson = null;
                 }
                 game.board = new Board($('#canvas'));
+                game.board.nplayers = json.NPlayers;
+                // Observers have no seat
+                game.player = json.Seat == -1 ? null : COLORS[json.Seat];
                 initBoard(game.board);
                 $('#vertices').innerText = game.board.points.length;
+                if (json.Rules) {
+                    const rules = JSON.parse(json.Rules);
+                    $('#chat').value += `Rules: ${rules.Ko} ko, ${rules.Scoring} scoring, komi ${rules.Komi}${rules.Suicide ? ', suicide allowed' : ''}\n`;
+                }
+            }
+            if (json.Action == "Watch") {
+                $('#chat').value += `Watching game ${json.Key}\n`;
+            }
+            if (json.Action == "Import") {
+                $('#chat').value += `Imported record, ${title(json.Player)} to play\n`;
             }
             const pts = JSON.parse(json.Payload);
+            if (pts.length != game.board.points.length) {
+                $('#chat').value += `The record's board can't be drawn here\n`;
+                return;
+            }
             game.board.lastId = getLastMove(game.board.history, pts);
+            if (game.review) {
+                game.board.lastId = game.review.LastMove == -1 ? null : game.review.LastMove;
+            }
             game.board.history.push(JSON.stringify(pts));
             game.board.loadPoints(pts);
             game.board.repaint();
             game.board.player = json.Player;
             game.passes = 0;
-            const [bscore, wscore] = game.board.getScores();
-            $('#black').innerText = bscore;
-            $('#white').innerText = wscore;
+            showScores(game);
             return;
         }
         if (json.Action == "Move-AI") {
+            // Player is the one who moved
             const pt = JSON.parse(json.Payload).Point;
             if (pt == -1) {
-                json.Payload = json.Player;
-                json.Player = json.Player == 'white' ? 'black' : 'white';
+                json.Payload = title(json.Player);
+                json.Player = game.board.nextPlayer(json.Player);
                 json.Action = 'Pass';
                 // Fall through to case below
             } else {
                 game.board.lastId = pt;
                 game.board.points[pt].player = json.Player;
-                game.board.cullCaptured(json.Player == 'white' ? 'black' : 'white');
+                for (let i=1; i<game.board.nplayers; i++) {
+                    game.board.cullCaptured(game.board.nextPlayer(json.Player, i));
+                }
                 game.board.cullCaptured(json.Player);
                 game.board.history.push(JSON.stringify(game.board.savePoints()));
                 game.board.repaint();
-                game.board.player = json.Player == 'white' ? 'black' : 'white';
+                game.board.player = game.board.nextPlayer(json.Player);
                 game.passes = 0;
-                const [bscore, wscore] = game.board.getScores();
-                $('#black').innerText = bscore;
-                $('#white').innerText = wscore;
+                showScores(game);
                 return;
             }
         }
+        if (json.Action == "Error") {
+            // Rejected move, the server follows up with the current position
+            // A failed resume means our seat is gone
+            if (!game.board.history.length) {
+                endGame(game);
+            }
+            $('#chat').value += `Server: ${json.Payload}\n`;
+            $('#chat').scrollTop = $('#chat').scrollHeight;
+            return;
+        }
         if (json.Action == "Chat") {
             $('#chat').value += `${json.Player}: ${json.Payload}\n`;
             $('#chat').scrollTop = $('#chat').scrollHeight;
@@ -128,25 +316,98 @@ function setupListeners(game) {
         if (json.Action == "Pass") {
             game.board.player = json.Player;
             $('#chat').value += `${json.Payload}: has passed\n`;
-            if (++game.passes >= 2) {
-                $('#chat').value += `Two passes in a row. The game is over!\n`;
-                const [bscore, wscore] = game.board.getScores();
-                const canvas = $('#canvas');
-                const ctx = canvas.getContext('2d');
-                drawText(ctx, `Black: ${bscore}`, new Point(canvas.width/2, 300), 'red', 'bold 48px sans', true);
-                drawText(ctx, `White: ${wscore}`, new Point(canvas.width/2, 350), 'red', 'bold 48px sans', true);
+            if (++game.passes >= game.board.nplayers) {
+                $('#chat').value += `Everyone passed in a row. The game is over!\n`;
             }
             $('#chat').scrollTop = $('#chat').scrollHeight;
             return;
         }
-        if (json.Action == "Concede") {
-            $('#chat').value += `Concession!\n`;
+        if (json.Action == "Score") {
+            // Server marks the dead stones it is sure of, click groups to change them
+            const state = JSON.parse(json.Payload);
+            if (!game.scoring) {
+                $('#chat').value += `Click groups to mark them dead, then press Accept Score\n`;
+            }
+            game.scoring = true;
+            game.passes = game.board.nplayers;
+            game.board.dead = state.Dead;
+            game.board.repaint();
+            const waiting = COLORS.slice(0, state.Accepted.length).filter((c, i) => !state.Accepted[i]).map(title);
+            $('#scores').innerHTML = state.Scores.map((s, i) => `${title(COLORS[i])}: ${s}`).join('<br>');
+            $('#chat').value += `Waiting for ${waiting.join(', ')} to accept\n`;
+            $('#chat').scrollTop = $('#chat').scrollHeight;
+            return;
+        }
+        if (json.Action == "UndoRequest") {
+            $('#chat').value += `${json.Player} asks to take back their last move\n`;
+            $('#chat').scrollTop = $('#chat').scrollHeight;
+            return;
+        }
+        if (json.Action == "UndoDecline") {
+            $('#chat').value += `${json.Player} declined ${json.Payload}'s undo\n`;
+            $('#chat').scrollTop = $('#chat').scrollHeight;
+            return;
+        }
+        if (json.Action == "Disconnect") {
+            $('#chat').value += `${json.Player} has disconnected\n`;
+            $('#chat').scrollTop = $('#chat').scrollHeight;
+            return;
+        }
+        if (json.Action == "Reconnect") {
+            $('#chat').value += `${json.Player} has reconnected\n`;
+            $('#chat').scrollTop = $('#chat').scrollHeight;
+            return;
+        }
+        if (json.Action == "Declined") {
+            endGame(game);
+            game.passes = game.board.nplayers;
+            $('#chat').value += `${json.Bot} declined the challenge\n`;
+            $('#chat').scrollTop = $('#chat').scrollHeight;
+            return;
+        }
+        if (json.Action == "Abandoned") {
+            endGame(game);
+            game.scoring = false;
+            game.passes = game.board.nplayers;
+            $('#chat').value += `Everyone left, the game was abandoned\n`;
+            $('#chat').scrollTop = $('#chat').scrollHeight;
+            return;
+        }
+        if (json.Action == "Result") {
+            const state = JSON.parse(json.Payload);
+            endGame(game);
+            game.scoring = false;
+            game.board.dead = state.Dead;
+            game.board.repaint();
+            $('#scores').innerHTML = state.Scores.map((s, i) => `${title(COLORS[i])}: ${s}`).join('<br>');
+            const canvas = $('#canvas');
+            const ctx = canvas.getContext('2d');
+            state.Scores.forEach((score, i) => {
+                drawText(ctx, `${title(COLORS[i])}: ${score}`, new Point(canvas.width/2, 300+50*i), 'red', 'bold 48px sans', true);
+            });
+            $('#chat').value += json.Player ? `${json.Player} wins!\n` : `It's a draw!\n`;
+            const levels = (state.Players || []).map((p, i) => p ? `${title(COLORS[i])}: ${p}` : null).filter(p => p);
+            if (levels.length) {
+                $('#chat').value += `Computer levels: ${levels.join(', ')}\n`;
+            }
+            $('#chat').scrollTop = $('#chat').scrollHeight;
+            return;
+        }
+        if (json.Action == "Concede" || json.Action == "Forfeit" || json.Action == "Timeout") {
+            endGame(game);
+            game.scoring = false;
+            const why = {
+                Concede: 'concedes',
+                Forfeit: 'left the game and forfeits',
+                Timeout: 'ran out of time'
+            };
+            $('#chat').value += `${json.Player} ${why[json.Action]}!\n`;
             $('#chat').value += `${json.Payload} wins!\n`;
             $('#chat').scrollTop = $('#chat').scrollHeight;
             const ctx = canvas.getContext('2d');
             drawText(ctx, `${json.Payload} wins!`, new Point(canvas.width/2, 300), 'red', 'bold 48px sans', true);
             // Manually end the game
-            game.passes = 2;
+            game.passes = game.board.nplayers;
             return;
         }
     }
@@ -162,21 +423,56 @@ window.addEventListener('load', () => {
     let player = null;
     let game = null;
 
+    // Stop reconnecting to the game we are leaving
+    function leave() {
+        if (!game) return;
+        game.left = true;
+        if (game.conn) game.conn.close();
+    }
+
     $('#new').addEventListener('click', () => {
+        leave();
         aigame = false;
         game = {board: new Board(canvas), player: 'black', passes: 0};
+        game.board.nplayers = parseInt($('#nplayers').value);
         initBoard(game.board); 
-        const pts = game.board.savePoints();
-        //console.log(pts);
-        //console.log(game.board.neighbors);
-        //console.log(getPointsNeighbors(game));
         game.conn = new WebSocket(`ws://${location.host}/ws`);
         game.conn.onopen = () => {
-            game.conn.send(JSON.stringify({Action: 'New', BoardPlan: boardjson ? boardjson : "", Payload: JSON.stringify(pts)}));
+            // The server keeps the authoritative board so it needs the neighbors
+            game.conn.send(JSON.stringify({
+                Action: 'New', 
+                BoardPlan: boardjson ? boardjson : "", 
+                Payload: getPointsNeighbors(game), 
+                Rules: getRules(),
+                Clock: getClock(),
+                NPlayers: game.board.nplayers
+            }));
         };
         setupListeners(game);
     });
 
+    // Empty for untimed games, times are sent in milliseconds
+    function getClock() {
+        if (!$('#clock-kind').value) return "";
+        return JSON.stringify({
+            Kind: $('#clock-kind').value,
+            Main: Math.round((parseFloat($('#clock-main').value) || 0)*60000),
+            Increment: Math.round((parseFloat($('#clock-increment').value) || 0)*1000),
+            Periods: parseInt($('#clock-periods').value) || 0,
+            Period: Math.round((parseFloat($('#clock-period').value) || 0)*1000),
+            Stones: parseInt($('#clock-stones').value) || 0
+        });
+    }
+
+    function getRules() {
+        return JSON.stringify({
+            Ko: $('#ko').value,
+            Scoring: $('#scoring').value,
+            Suicide: $('#suicide').checked,
+            Komi: parseFloat($('#komi').value) || 0
+        });
+    }
+
     function getPointsNeighbors(game) {
         const pts = game.board.savePoints();
         const ns = game.board.neighbors;
@@ -191,8 +487,10 @@ window.addEventListener('load', () => {
 
     // We don't send a board plan, we
     $('#new-ai').addEventListener('click', () => {
+        leave();
         aigame = true;
         game = {board: new Board(canvas), player: 'black', passes: 0};
+        game.board.nplayers = parseInt($('#nplayers').value);
         initBoard(game.board); 
         game.conn = new WebSocket(`ws://${location.host}/ws`);
         game.conn.onopen = () => {
@@ -200,36 +498,192 @@ window.addEventListener('load', () => {
             game.conn.send(JSON.stringify({
                 Action: 'New-AI', 
                 BoardPlan: boardjson ? boardjson : "", 
-                Payload: getPointsNeighbors(game)
+                Payload: getPointsNeighbors(game),
+                Level: $('#level').value,
+                Player: $('#color').value,
+                Handicap: parseInt($('#handicap').value) || 0,
+                Undo: $('#undo-policy').value,
+                Rules: getRules(),
+                Clock: getClock(),
+                NPlayers: game.board.nplayers
             }));
         };
         setupListeners(game);
     })
 
+    // Computers play each other, we watch
+    $('#exhibition').addEventListener('click', () => {
+        leave();
+        aigame = false;
+        game = {board: new Board(canvas), player: null, passes: 0, watching: true};
+        game.board.nplayers = parseInt($('#nplayers').value);
+        initBoard(game.board); 
+        const levels = [$('#level').value];
+        for (let i=1; i<game.board.nplayers; i++) {
+            levels.push($('#level2').value);
+        }
+        game.conn = new WebSocket(`ws://${location.host}/ws`);
+        game.conn.onopen = () => {
+            game.conn.send(JSON.stringify({
+                Action: 'Exhibition', 
+                BoardPlan: boardjson ? boardjson : "", 
+                Payload: getPointsNeighbors(game),
+                Levels: levels,
+                Pace: parseInt($('#pace').value) || 0,
+                Rules: getRules(),
+                Clock: getClock(),
+                NPlayers: game.board.nplayers
+            }));
+        };
+        setupListeners(game);
+    });
+
+    // Outside engine registered as a bot, it plays the next seat after ours
+    $('#challenge').addEventListener('click', () => {
+        const idx = $('#bots').selectedIndex;
+        if (idx == -1) return;
+        leave();
+        aigame = true;
+        game = {board: new Board(canvas), player: $('#color').value, passes: 0};
+        game.board.nplayers = parseInt($('#nplayers').value);
+        initBoard(game.board); 
+        game.conn = new WebSocket(`ws://${location.host}/ws`);
+        game.conn.onopen = () => {
+            game.conn.send(JSON.stringify({
+                Action: 'Challenge', 
+                Bot: $('#bots').options[idx].value,
+                BoardPlan: boardjson ? boardjson : "", 
+                Payload: getPointsNeighbors(game),
+                Player: $('#color').value,
+                Rules: getRules(),
+                Clock: getClock(),
+                NPlayers: game.board.nplayers
+            }));
+            $('#chat').value += `Waiting for ${$('#bots').options[idx].value} to accept\n`;
+        };
+        setupListeners(game);
+    });
+
     $('#join').addEventListener('click', () => {
         aigame = false;
         const sel = $('select[name="games-list"]');    
         if (sel.selectedIndex == -1) return;
         const id = sel.options[sel.selectedIndex].value;
         if (game && game.id == id) return;
+        leave();
         game = {board: new Board(canvas), player: 'white', passes: 0};
         initBoard(game.board); 
-        game.conn = new WebSocket(`ws://${location.host}/ws`);
         game.id = parseInt(id);
+        // Resume our seat if we had one
+        if (localStorage.getItem(tokenKey(game.id))) {
+            resume(game);
+            return;
+        }
+        game.conn = new WebSocket(`ws://${location.host}/ws`);
         game.conn.onopen = () => {
             game.conn.send(JSON.stringify({Action: 'Join', Key: game.id}));
         };
         setupListeners(game);
     });
     
+    $('#watch').addEventListener('click', () => {
+        aigame = false;
+        const sel = $('select[name="games-list"]');    
+        if (sel.selectedIndex == -1) return;
+        const id = sel.options[sel.selectedIndex].value;
+        if (game && game.id == id) return;
+        leave();
+        game = {board: new Board(canvas), player: null, passes: 0, watching: true};
+        initBoard(game.board); 
+        game.conn = new WebSocket(`ws://${location.host}/ws`);
+        game.id = parseInt(id);
+        game.conn.onopen = () => {
+            game.conn.send(JSON.stringify({Action: 'Watch', Key: game.id}));
+        };
+        setupListeners(game);
+    });
+    
+    // Selected game, or the one we are in
+    $('#record').addEventListener('click', () => {
+        const sel = $('select[name="games-list"]');    
+        const id = sel.selectedIndex != -1 ? sel.options[sel.selectedIndex].value : (game && game.id);
+        if (id === undefined || id === null || id < 0) return;
+        location.href = `/record?key=${id}`;
+    });
+
+    // Review sessions are checked and replayed by the server, we follow along
+    function startReview(req) {
+        leave();
+        aigame = false;
+        game = {board: new Board(canvas), player: null, passes: 0, watching: true, id: -1};
+        initBoard(game.board); 
+        game.conn = new WebSocket(`ws://${location.host}/ws`);
+        game.conn.onopen = () => {
+            game.conn.send(JSON.stringify(req));
+        };
+        setupListeners(game);
+    }
+
+    $('#import').addEventListener('change', () => {
+        const file = $('#import').files[0];
+        if (!file) return;
+        file.text().then(text => {
+            startReview({Action: 'Review', Payload: text});
+            $('#import').value = '';
+        });
+    });
+
+    $('#review').addEventListener('click', () => {
+        const sel = $('select[name="games-list"]');    
+        const id = sel.selectedIndex != -1 ? sel.options[sel.selectedIndex].value : (game && !game.review && game.id);
+        if (id === undefined || id === null || id === false || id < 0) return;
+        startReview({Action: 'Review', Key: parseInt(id)});
+    });
+
+    $('#review-join').addEventListener('click', () => {
+        const key = parseInt($('#review-key').value);
+        if (isNaN(key)) return;
+        startReview({Action: 'ReviewJoin', Key: key});
+    });
+
+    function reviewAction(action, payload) {
+        if (!game || !game.review) return;
+        game.conn.send(JSON.stringify({Action: action, Key: game.id, Payload: payload, Level: $('#level').value}));
+    }
+
+    $('#review-back').addEventListener('click', () => reviewAction('ReviewBack', ''));
+    $('#review-forward').addEventListener('click', () => reviewAction('ReviewForward', $('#variations').value || '0'));
+    $('#review-eval').addEventListener('click', () => reviewAction('ReviewEval', ''));
+    $('#review-record').addEventListener('click', () => reviewAction('ReviewRecord', ''));
+    $('#review-comment').addEventListener('click', () => {
+        reviewAction('ReviewComment', $('#message').value);
+        $('#message').value = '';
+    });
+
     $('#canvas').addEventListener('mousemove', (e) => {
-        if (!game || !game.board || game.player != game.board.player || game.passes >= 2) return;
+        if (!game || !game.board || game.player != game.board.player || game.passes >= game.board.nplayers) return;
         game.board.hover(e.offsetX, e.offsetY);
         game.board.repaint();
     });
 
     $('#canvas').addEventListener('click', (e) => {
-        if (!game || !game.board || game.player != game.board.player || game.passes >= 2) return;
+        // Anyone in a review can try a move
+        if (game && game.review) {
+            const p = game.board.pointAt(e.offsetX, e.offsetY);
+            if (p) {
+                reviewAction('ReviewPlay', JSON.stringify({Point: p.id}));
+            }
+            return;
+        }
+        if (game && game.watching) return;
+        if (game && game.scoring) {
+            const p = game.board.pointAt(e.offsetX, e.offsetY);
+            if (p && p.player) {
+                game.conn.send(JSON.stringify({Action: 'MarkDead', Key: game.id, Payload: JSON.stringify({Point: p.id})}));
+            }
+            return;
+        }
+        if (!game || !game.board || game.player != game.board.player || game.passes >= game.board.nplayers) return;
         const res = game.board.click(e.offsetX, e.offsetY);
         if (!res) return;
         game.board.repaint();
@@ -243,7 +697,7 @@ window.addEventListener('load', () => {
     });
 
     function sendMessage() {
-        if (!game || !game.conn) return;
+        if (!game || !game.conn || game.watching) return;
         game.conn.send(JSON.stringify({Key: game.id, Action: 'Chat', Payload: $('#message').value}));
         $('#message').value = '';
     }
@@ -267,40 +721,48 @@ window.addEventListener('load', () => {
 
     // This conn only used for listing games
     conn.onmessage = e => {
+        // Games in progress, Open ones have a free seat
         const json = JSON.parse(e.data);
 
-        // List of integer game ids
-        json.sort((a,b) => a-b);
+        // Plus the ones we can resume
+        savedTokens().forEach(key => {
+            if (!json.some(g => g.Key == key)) json.push({Key: key, Open: true});
+        });
+        json.sort((a,b) => a.Key-b.Key);
 
         const select = $('select[name="games-list"]');
-        const toAdd = [];
-        const games = [...select.options].map(opt => parseInt(opt.value));
-        if (game && games.includes(game.id)) {
-            for (let i=0; i<select.options.length; i++) {
-                const opt = select.options[i];
-                if (parseInt(opt.value) == game.id) {
-                    select.remove(i);
-                    break;
-                }
-            }
-        } 
+        const label = g => {
+            const levels = (g.Players || []).filter(p => p);
+            const vs = levels.length ? ` vs ${levels.join(', ')}` : '';
+            return g.Open ? `Game ${g.Key}${vs}` : `Game ${g.Key}${vs} (watch)`;
+        };
         for (let i=0; i<select.options.length; i++) {
             const opt = select.options[i];
-            if (!json.includes(parseInt(opt.value))) {
+            const g = json.find(g => g.Key == parseInt(opt.value));
+            if (!g || (game && game.id == g.Key)) {
                 select.remove(i--);
+            } else {
+                opt.innerHTML = label(g);
             }
         }
-        json.forEach(key => {
-            if (!games.includes(key) && !(game && game.id == key)) {
+        const games = [...select.options].map(opt => parseInt(opt.value));
+        json.forEach(g => {
+            if (!games.includes(g.Key) && !(game && game.id == g.Key)) {
                 const opt = document.createElement('option');
-                opt.value = key;
-                opt.innerHTML = `Game ${key}`;
+                opt.value = g.Key;
+                opt.innerHTML = label(g);
                 select.appendChild(opt);
             }
         });
     }
     
     const connBoards = new WebSocket(`ws://${location.host}/boards`);
+    // Bots are listed on the game socket
+    const connBots = new WebSocket(`ws://${location.host}/ws`);
+
+    setInterval(e => {
+        if (game) showClocks(game);
+    }, 200);
 
     setInterval(e => {
         if (!conn.readyState == 1) return;
@@ -312,6 +774,25 @@ window.addEventListener('load', () => {
         connBoards.send(JSON.stringify({Action: 'List'}));
     }, 1000);
 
+    setInterval(e => {
+        if (connBots.readyState != 1) return;
+        connBots.send(JSON.stringify({Action: 'Bots'}));
+    }, 1000);
+
+    connBots.onmessage = e => {
+        const msg = JSON.parse(e.data);
+        if (msg.Action != 'Bots') return;
+        const selected = $('#bots').value;
+        $('#bots').innerHTML = '';
+        JSON.parse(msg.Payload).sort((a,b) => a.Name.localeCompare(b.Name)).forEach(bot => {
+            const opt = document.createElement('option');
+            opt.value = bot.Name;
+            opt.innerText = bot.Busy ? `${bot.Name} (busy)` : bot.Name;
+            $('#bots').appendChild(opt);
+        });
+        $('#bots').value = selected;
+    };
+
     $('#load').addEventListener('click', () => {
         const idx = $('#boards').selectedIndex;
         if (idx == -1) return;
@@ -343,6 +824,26 @@ window.addEventListener('load', () => {
         }
     }
 
+    $('#accept').addEventListener('click', () => {
+        if (!game || !game.conn || !game.scoring) return;
+        game.conn.send(JSON.stringify({Key: game.id, Action: 'AcceptScore'}));
+    });
+
+    $('#undo').addEventListener('click', () => {
+        if (!game || !game.conn || game.watching) return;
+        game.conn.send(JSON.stringify({Key: game.id, Action: 'UndoRequest'}));
+    });
+
+    $('#undo-accept').addEventListener('click', () => {
+        if (!game || !game.conn || game.watching) return;
+        game.conn.send(JSON.stringify({Key: game.id, Action: 'UndoAccept'}));
+    });
+
+    $('#undo-decline').addEventListener('click', () => {
+        if (!game || !game.conn || game.watching) return;
+        game.conn.send(JSON.stringify({Key: game.id, Action: 'UndoDecline'}));
+    });
+
     $('#concede').addEventListener('click', () => {
         if (!game || !game.conn) return;
         game.conn.send(JSON.stringify({Key: game.id, Action: 'Concede'}));
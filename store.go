@@ -0,0 +1,258 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "log"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+
+    ai "github.com/aorliche/web-nongrid-go/ai"
+    "github.com/aorliche/web-nongrid-go/clock"
+)
+
+// Games are saved as JSON files under games/, one per game, after every change
+// On startup unfinished games are loaded back by replaying their moves
+// and their AI seats are started again, players Resume with their tokens
+// Clocks are saved as of the last move, a turn in progress starts over
+
+var gamesDir = "games"
+
+type SavedGame struct {
+    Key int
+    BoardPlan string
+    Neighbors [][]int
+    NPlayers int
+    Rules *ai.Rules
+    // First position and turn, set for handicap games
+    Start []int
+    StartTurn int
+    // Point played each turn, -1 for a pass
+    Moves []int
+    // Empty for humans, the level for AI seats
+    Players []string
+    // Bot in each seat, nil without bots
+    Bots []string
+    Pace int
+    // Reconnect tokens of the seats
+    Tokens []string
+    Chat []ChatLine
+    // Time left for each seat at the last move, nil for untimed games
+    Clocks []*clock.Clock
+    UndoPolicy UndoPolicy
+    Undos []int
+    State GameState
+    // Set once the game is over
+    Result string
+}
+
+func gamePath(key int) string {
+    return gamesDir + "/" + strconv.Itoa(key) + ".json"
+}
+
+// Call with the game locked
+func (game *Game) Save() error {
+    first := game.History[0]
+    saved := SavedGame{
+        Key: game.Key,
+        BoardPlan: game.BoardPlan,
+        Neighbors: first.Neighbors,
+        NPlayers: first.NPlayers,
+        Rules: first.GetRules(),
+        StartTurn: first.Turn,
+        Moves: make([]int, 0, len(game.History)-1),
+        Players: game.Players,
+        Bots: game.Bots,
+        Pace: game.Pace,
+        Tokens: game.Tokens,
+        Chat: game.Chat,
+        Clocks: game.Clocks,
+        UndoPolicy: game.UndoPolicy,
+        Undos: game.Undos,
+        State: game.State,
+        Result: game.Result,
+    }
+    // Every change is saved
+    game.Active = time.Now()
+    for _, player := range first.Points {
+        if player != -1 {
+            saved.Start = first.Points
+            break
+        }
+    }
+    for i := 1; i < len(game.History); i++ {
+        saved.Moves = append(saved.Moves, ai.LastMove(game.History[:i], game.History[i]))
+    }
+    jsn, err := json.Marshal(saved)
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    err = os.MkdirAll(gamesDir, 0755)
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    // Write then rename so a crash never leaves half a file
+    tmp := gamePath(game.Key) + ".tmp"
+    err = os.WriteFile(tmp, jsn, 0644)
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    err = os.Rename(tmp, gamePath(game.Key))
+    if err != nil {
+        log.Println(err)
+    }
+    return err
+}
+
+func ReadSaved(path string) (*SavedGame, error) {
+    dat, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    var saved SavedGame
+    err = json.Unmarshal(dat, &saved)
+    if err != nil {
+        return nil, err
+    }
+    return &saved, nil
+}
+
+// Rebuild a game by replaying its moves with the rules
+func (saved *SavedGame) ToGame() (*Game, error) {
+    if len(saved.Players) != saved.NPlayers {
+        return nil, errors.New("Bad players")
+    }
+    // Saved before there were tokens
+    if saved.Tokens == nil {
+        saved.Tokens = make([]string, saved.NPlayers)
+    }
+    if len(saved.Tokens) != saved.NPlayers {
+        return nil, errors.New("Bad tokens")
+    }
+    if saved.Bots != nil && len(saved.Bots) != saved.NPlayers {
+        return nil, errors.New("Bad bots")
+    }
+    if saved.Clocks != nil && len(saved.Clocks) != saved.NPlayers {
+        return nil, errors.New("Bad clocks")
+    }
+    // Saved before there were undos
+    if saved.UndoPolicy == "" {
+        saved.UndoPolicy = UndoAlways
+    }
+    if saved.Undos == nil {
+        saved.Undos = make([]int, saved.NPlayers)
+    }
+    if len(saved.Undos) != saved.NPlayers {
+        return nil, errors.New("Bad undos")
+    }
+    for _, c := range saved.Clocks {
+        if c == nil || c.Control == nil {
+            return nil, errors.New("Bad clocks")
+        }
+    }
+    points := make([]int, len(saved.Neighbors))
+    for i := range points {
+        points[i] = -1
+    }
+    if saved.Start != nil {
+        if len(saved.Start) != len(points) {
+            return nil, errors.New("Bad start position")
+        }
+        copy(points, saved.Start)
+    }
+    pn := PointsNeighbors{Points: points, Neighbors: saved.Neighbors}
+    board, err := pn.ToBoard(saved.NPlayers)
+    if err != nil {
+        return nil, err
+    }
+    board.Rules = saved.Rules
+    board.Turn = saved.StartTurn
+    history := []*ai.Board{board}
+    for _, p := range saved.Moves {
+        next, err := board.Move(history, p, board.Turn % board.NPlayers)
+        if err != nil {
+            return nil, err
+        }
+        history = append(history, next)
+        board = next
+    }
+    game := &Game{
+        Key: saved.Key,
+        BoardPlan: saved.BoardPlan,
+        Json: BoardToJson(board),
+        Conns: make([]*Conn, saved.NPlayers),
+        Player: colors[board.Turn % board.NPlayers],
+        History: history,
+        Players: saved.Players,
+        Bots: saved.Bots,
+        Pace: saved.Pace,
+        Tokens: saved.Tokens,
+        Chat: saved.Chat,
+        Clocks: saved.Clocks,
+        UndoPolicy: saved.UndoPolicy,
+        Undos: saved.Undos,
+        State: saved.State,
+        Active: time.Now(),
+        Result: saved.Result,
+    }
+    // Saved before there were states
+    if game.State == "" {
+        game.State = Waiting
+    }
+    return game, nil
+}
+
+// Load unfinished games and start their AI
+func LoadGames() {
+    dir, err := os.Open(gamesDir)
+    if err != nil {
+        // Nothing saved yet
+        return
+    }
+    files, err := dir.Readdir(0)
+    if err != nil {
+        log.Println(err)
+        return
+    }
+    for _, v := range files {
+        if v.IsDir() || !strings.HasSuffix(v.Name(), ".json") {
+            continue
+        }
+        saved, err := ReadSaved(gamesDir + "/" + v.Name())
+        if err != nil {
+            log.Println(v.Name(), err)
+            continue
+        }
+        // New games don't overwrite finished ones
+        games.Reserve(saved.Key)
+        if saved.Result != "" {
+            continue
+        }
+        game, err := saved.ToGame()
+        if err != nil {
+            log.Println(v.Name(), err)
+            continue
+        }
+        game.Mutex.Lock()
+        games.Put(game)
+        // Nobody is connected yet, players who don't come back forfeit
+        for seat, token := range game.Tokens {
+            if token != "" {
+                game.StartAbandonTimer(seat)
+            }
+        }
+        if board := game.History[len(game.History)-1]; board.GameOver(game.History) {
+            game.StartScoring()
+        } else if game.HasAI() || game.HasBots() {
+            game.StartAI()
+        }
+        game.UpdateSeated()
+        game.Mutex.Unlock()
+        log.Println("Loaded game", game.Key)
+    }
+}
@@ -0,0 +1,495 @@
+package tiling
+
+import (
+    "encoding/json"
+    "errors"
+    "math"
+    "sort"
+
+    ai "github.com/aorliche/web-nongrid-go/ai"
+)
+
+// Go port of the board construction in static/js/board.js and primitives.js
+// A board plan is a list of rounds, each placing or filling polygons around
+// the free vertices closest to the center of an 800x800 canvas
+// Points come out in the same order as in the browser, so point ids agree
+
+const EdgeLen = 40
+const CanvasSize = 800
+
+type Shape struct {
+    // Number of sides, 0 to skip a vertex and -1 to never fill it
+    N int `json:"n"`
+    Txt string `json:"txt"`
+}
+
+type Round struct {
+    // "fill" or "place"
+    Typ string `json:"typ"`
+    Sav []Shape `json:"sav"`
+}
+
+type Point struct {
+    X float64
+    Y float64
+}
+
+func (p Point) Add(q Point) Point {
+    return Point{p.X + q.X, p.Y + q.Y}
+}
+
+func (p Point) Sub(q Point) Point {
+    return Point{p.X - q.X, p.Y - q.Y}
+}
+
+func (p Point) Mult(a float64) Point {
+    return Point{p.X * a, p.Y * a}
+}
+
+func (p Point) Mag() float64 {
+    return math.Sqrt(p.X*p.X + p.Y*p.Y)
+}
+
+func (p Point) Dist(q Point) float64 {
+    return p.Sub(q).Mag()
+}
+
+func (p Point) Nearby(q Point) bool {
+    return p.Dist(q) < 1e-3
+}
+
+func (p Point) Rotate(theta float64) Point {
+    return Point{p.X*math.Cos(theta) - p.Y*math.Sin(theta), p.X*math.Sin(theta) + p.Y*math.Cos(theta)}
+}
+
+type Edge struct {
+    Points [2]Point
+}
+
+type Polygon struct {
+    N int
+    Center Point
+    Edges []Edge
+}
+
+// Center point, edge point, number of edges
+func NewPolygon(cp Point, ep Point, n int) *Polygon {
+    poly := &Polygon{N: n, Center: cp, Edges: make([]Edge, 0, n)}
+    theta := (math.Pi - 2*math.Pi/float64(n)) / 2
+    for i := 0; i < n; i++ {
+        d := cp.Sub(ep)
+        d2 := d.Mult(EdgeLen / d.Mag())
+        np := d2.Rotate(theta).Add(ep)
+        poly.Edges = append(poly.Edges, Edge{Points: [2]Point{ep, np}})
+        ep = np
+    }
+    return poly
+}
+
+func ccw(a Point, b Point, c Point) float64 {
+    return (b.X - a.X) * (c.Y - a.Y) - (c.X - a.X) * (b.Y - a.Y)
+}
+
+// Point inside the polygon
+func (poly *Polygon) Contains(p Point) bool {
+    sign := func(x float64) int {
+        if x > 0 {
+            return 1
+        }
+        return -1
+    }
+    for _, e := range poly.Edges {
+        // Not sure about edge direction, use center as reference point
+        if sign(ccw(e.Points[0], e.Points[1], poly.Center)) != sign(ccw(e.Points[0], e.Points[1], p)) {
+            return false
+        }
+    }
+    return true
+}
+
+// One of the points on the edge of the polygon
+func (poly *Polygon) EdgeHas(p Point) bool {
+    for _, e := range poly.Edges {
+        if e.Points[0].Nearby(p) || e.Points[1].Nearby(p) {
+            return true
+        }
+    }
+    return false
+}
+
+func (poly *Polygon) pointsNextTo(p Point) []Point {
+    ps := make([]Point, 0)
+    for _, e := range poly.Edges {
+        if e.Points[0].Nearby(p) {
+            ps = append(ps, e.Points[1])
+        } else if e.Points[1].Nearby(p) {
+            ps = append(ps, e.Points[0])
+        }
+    }
+    return ps
+}
+
+// A vertex of the tiling and the polygons around it
+type Vertex struct {
+    Point
+    polys []*Polygon
+}
+
+type Tiling struct {
+    Polygons []*Polygon
+    Points []*Vertex
+    // Sorted by point id
+    Neighbors [][]int
+    // Points that are never filled/placed on
+    nofill map[*Vertex]bool
+}
+
+func thetaFromN(n int) float64 {
+    return math.Pi - 2*math.Pi/float64(n)
+}
+
+func polyDistFromN(n int) float64 {
+    theta := 2*math.Pi/float64(n)
+    return math.Sqrt(EdgeLen*EdgeLen/2/(1-math.Cos(theta)))
+}
+
+func nearby(a float64, b float64) bool {
+    return math.Abs(a-b) < 1e-3
+}
+
+func pointFreeAngle(v *Vertex) float64 {
+    sum := 0.0
+    for _, poly := range v.polys {
+        sum += thetaFromN(poly.N)
+    }
+    return 2*math.Pi - sum
+}
+
+// Start and end angles of a polygon around a vertex
+func startEndAngles(poly *Polygon, p Point) (float64, float64) {
+    ps := poly.pointsNextTo(p)
+    d0, d1 := ps[0].Sub(p), ps[1].Sub(p)
+    t0 := math.Atan2(d0.Y, d0.X)
+    t1 := math.Atan2(d1.Y, d1.X)
+    if t0 < 0 {
+        t0 += 2*math.Pi
+    }
+    if t1 < 0 {
+        t1 += 2*math.Pi
+    }
+    // Wraparound
+    // Assume no polys take more than pi radians
+    if math.Abs(t0 - t1) > math.Pi {
+        if t0 < math.Pi {
+            t0 += 2*math.Pi
+        } else {
+            t1 += 2*math.Pi
+        }
+    }
+    if t1 < t0 {
+        t0, t1 = t1, t0
+    }
+    return t0, t1
+}
+
+// Starts and ends of the polygons around a vertex and the free angles between them
+func freeAngles(v *Vertex) ([]float64, []float64, []float64) {
+    if len(v.polys) == 0 {
+        return []float64{0}, []float64{0}, []float64{2*math.Pi}
+    }
+    starts := make([]float64, 0)
+    ends := make([]float64, 0)
+    for _, poly := range v.polys {
+        start, end := startEndAngles(poly, v.Point)
+        starts = append(starts, start)
+        ends = append(ends, end)
+    }
+    sort.Float64s(starts)
+    sort.Float64s(ends)
+    free := make([]float64, len(ends))
+    for i := range ends {
+        if i == len(ends)-1 {
+            free[i] = starts[0] + 2*math.Pi - ends[i]
+        } else {
+            free[i] = starts[i+1] - ends[i]
+        }
+    }
+    return starts, ends, free
+}
+
+func (t *Tiling) addPoly(poly *Polygon) {
+    t.Polygons = append(t.Polygons, poly)
+    addTo := func(v *Vertex) {
+        for _, p := range v.polys {
+            if p == poly {
+                return
+            }
+        }
+        v.polys = append(v.polys, poly)
+    }
+    for _, e := range poly.Edges {
+        donea, doneb := false, false
+        for _, v := range t.Points {
+            if !donea && e.Points[0].Nearby(v.Point) {
+                addTo(v)
+                donea = true
+            }
+            if !doneb && e.Points[1].Nearby(v.Point) {
+                addTo(v)
+                doneb = true
+            }
+            if donea && doneb {
+                break
+            }
+        }
+        if !donea {
+            t.Points = append(t.Points, &Vertex{Point: e.Points[0], polys: []*Polygon{poly}})
+        }
+        if !doneb {
+            t.Points = append(t.Points, &Vertex{Point: e.Points[1], polys: []*Polygon{poly}})
+        }
+    }
+}
+
+func (t *Tiling) polyOverlaps(poly *Polygon) bool {
+    for _, v := range t.Points {
+        if !poly.Contains(v.Point) {
+            continue
+        }
+        onEdge := false
+        for _, e := range poly.Edges {
+            if v.Nearby(e.Points[0]) || v.Nearby(e.Points[1]) {
+                onEdge = true
+                break
+            }
+        }
+        if !onEdge {
+            return true
+        }
+    }
+    return false
+}
+
+// Surround the vertex with polygons of m sides
+// Fails if the free angles cannot be filled exactly
+func (t *Tiling) fill(v *Vertex, m int, place bool) bool {
+    d := polyDistFromN(m)
+    theta := thetaFromN(m)
+    _, ends, free := freeAngles(v)
+    for i := range ends {
+        if nearby(free[i], 0) {
+            continue
+        }
+        n := free[i]/theta
+        // Like Math.round for positive numbers
+        N := int(math.Floor(n + 0.5))
+        if !nearby(n, float64(N)) {
+            return false
+        }
+        for j := 0; j < N; j++ {
+            a := ends[i] + theta/2 + float64(j)*theta
+            cp := Point{v.X + d*math.Cos(a), v.Y + d*math.Sin(a)}
+            poly := NewPolygon(cp, v.Point, m)
+            if place {
+                t.addPoly(poly)
+            } else if t.polyOverlaps(poly) {
+                return false
+            }
+        }
+    }
+    return true
+}
+
+// Add just one polygon to a vertex
+// Different from fill because it skips free areas that are too small
+func (t *Tiling) placeOne(v *Vertex, m int, place bool) bool {
+    d := polyDistFromN(m)
+    theta := thetaFromN(m)
+    _, ends, free := freeAngles(v)
+    for i := range ends {
+        if nearby(free[i], 0) {
+            continue
+        }
+        n := free[i]/theta
+        if nearby(n, 1) || n > 1 {
+            a := ends[i] + theta/2
+            cp := Point{v.X + d*math.Cos(a), v.Y + d*math.Sin(a)}
+            poly := NewPolygon(cp, v.Point, m)
+            if place {
+                t.addPoly(poly)
+            } else if t.polyOverlaps(poly) {
+                continue
+            }
+            return true
+        }
+    }
+    return false
+}
+
+// Vertices with free angle left that are closest to the center, by angle
+func (t *Tiling) nextFromCenter() []*Vertex {
+    cp := Point{CanvasSize/2, CanvasSize/2}
+    mind := math.Inf(1)
+    set := make([]*Vertex, 0)
+    for _, v := range t.Points {
+        if nearby(pointFreeAngle(v), 0) || t.nofill[v] {
+            continue
+        }
+        d := cp.Sub(v.Point).Mag()
+        if math.Abs(d-mind) < 1e-3 {
+            set = append(set, v)
+        } else if d < mind {
+            mind = d
+            set = []*Vertex{v}
+        }
+    }
+    angle := func(v *Vertex) float64 {
+        return math.Atan2(v.Y - cp.Y, v.X - cp.X)
+    }
+    sort.SliceStable(set, func(i, j int) bool {
+        return angle(set[i]) < angle(set[j])
+    })
+    return set
+}
+
+// One round of the plan
+func (t *Tiling) loop(round Round) error {
+    var points []*Vertex
+    if len(t.Points) == 0 {
+        points = []*Vertex{&Vertex{Point: Point{CanvasSize/2, CanvasSize/2}}}
+    } else {
+        points = t.nextFromCenter()
+    }
+    apply := func(shape Shape, v *Vertex, place bool) bool {
+        if shape.N <= 0 {
+            return true
+        }
+        if round.Typ == "fill" {
+            return t.fill(v, shape.N, place)
+        }
+        return t.placeOne(v, shape.N, place)
+    }
+    n := len(round.Sav)
+    for offset := 0; offset < n; offset++ {
+        allgood := true
+        for i, v := range points {
+            if !apply(round.Sav[(i+offset) % n], v, false) {
+                allgood = false
+                break
+            }
+        }
+        if !allgood {
+            continue
+        }
+        for k, v := range points {
+            shape := round.Sav[(k+offset) % n]
+            if shape.N == -1 {
+                t.nofill[v] = true
+            }
+            if !apply(shape, v, true) {
+                return errors.New("Failed to place polygons")
+            }
+        }
+        return nil
+    }
+    // No good placement found, the browser carries on as well
+    return nil
+}
+
+func (t *Tiling) initNeighbors() {
+    t.Neighbors = make([][]int, len(t.Points))
+    for i, v1 := range t.Points {
+        for j := i+1; j < len(t.Points); j++ {
+            v2 := t.Points[j]
+            if math.Abs(v1.Dist(v2.Point) - EdgeLen) >= 0.01 {
+                continue
+            }
+            // Check that they are part of the same polygon
+            // It can be that they aren't (on edge of board)
+            for _, poly := range t.Polygons {
+                if poly.EdgeHas(v1.Point) && poly.EdgeHas(v2.Point) {
+                    t.Neighbors[i] = append(t.Neighbors[i], j)
+                    t.Neighbors[j] = append(t.Neighbors[j], i)
+                    break
+                }
+            }
+        }
+    }
+}
+
+func ParsePlan(jsn string) ([]Round, error) {
+    var plan []Round
+    err := json.Unmarshal([]byte(jsn), &plan)
+    if err != nil {
+        return nil, err
+    }
+    for _, round := range plan {
+        if round.Typ != "fill" && round.Typ != "place" {
+            return nil, errors.New("Unknown round type " + round.Typ)
+        }
+        if len(round.Sav) == 0 {
+            return nil, errors.New("Empty round")
+        }
+    }
+    return plan, nil
+}
+
+func Build(plan []Round) (*Tiling, error) {
+    t := &Tiling{nofill: make(map[*Vertex]bool)}
+    for _, round := range plan {
+        err := t.loop(round)
+        if err != nil {
+            return nil, err
+        }
+    }
+    t.initNeighbors()
+    return t, nil
+}
+
+// Build from the JSON saved under boards/
+func FromJson(jsn string) (*Tiling, error) {
+    plan, err := ParsePlan(jsn)
+    if err != nil {
+        return nil, err
+    }
+    return Build(plan)
+}
+
+// The board initBoard in static/js/go.js builds without a board plan
+func DefaultPlan() []Round {
+    fill := func(ns ...int) Round {
+        round := Round{Typ: "fill"}
+        for _, n := range ns {
+            round.Sav = append(round.Sav, Shape{N: n})
+        }
+        return round
+    }
+    return []Round{
+        fill(6), fill(3), fill(4), fill(3), fill(4), fill(3),
+        fill(0, 4),
+        Round{Typ: "place", Sav: []Shape{Shape{N: 3}}},
+        fill(6), fill(3), fill(3),
+    }
+}
+
+func Default() *Tiling {
+    t, _ := Build(DefaultPlan())
+    return t
+}
+
+// An empty game board on the tiling
+func (t *Tiling) ToBoard(nPlayers int) *ai.Board {
+    points := make([]int, len(t.Points))
+    neighbors := make([][]int, len(t.Points))
+    for i := range points {
+        points[i] = -1
+        neighbors[i] = make([]int, len(t.Neighbors[i]))
+        copy(neighbors[i], t.Neighbors[i])
+    }
+    return &ai.Board{
+        Points: points,
+        Neighbors: neighbors,
+        NPlayers: nPlayers,
+        Turn: 0,
+    }
+}
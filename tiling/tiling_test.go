@@ -0,0 +1,58 @@
+package tiling
+
+import (
+    "math"
+    "testing"
+
+    ai "github.com/aorliche/web-nongrid-go/ai"
+)
+
+// Expected values come from running the same plans through static/js/board.js
+
+func TestHexOfTriangles(t *testing.T) {
+    tl, err := FromJson(`[{"typ":"fill","sav":[{"n":3,"txt":"Triangles"}]}]`)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(tl.Polygons) != 6 || len(tl.Points) != 7 {
+        t.Errorf("expected 6 polygons and 7 points got %d and %d", len(tl.Polygons), len(tl.Points))
+    }
+    expect := [][]int{{1, 2, 3, 4, 5, 6}, {0, 2, 3}, {0, 1, 6}, {0, 1, 4}, {0, 3, 5}, {0, 4, 6}, {0, 2, 5}}
+    for i := range expect {
+        if !ai.Equals(expect[i], tl.Neighbors[i]) {
+            t.Errorf("expected %v got %v", expect[i], tl.Neighbors[i])
+        }
+    }
+    p := tl.Points[1]
+    if math.Abs(p.X - 420) > 1e-9 || math.Abs(p.Y - 434.6410161513775) > 1e-9 {
+        t.Errorf("expected (420,434.64) got %v", p.Point)
+    }
+}
+
+func TestDefault(t *testing.T) {
+    tl := Default()
+    if len(tl.Points) != 100 {
+        t.Errorf("expected 100 points got %d", len(tl.Points))
+    }
+    expect := [][]int{{1, 5, 6}, {0, 2, 9, 15}, {1, 3, 15, 28}}
+    for i := range expect {
+        if !ai.Equals(expect[i], tl.Neighbors[i]) {
+            t.Errorf("expected %v got %v", expect[i], tl.Neighbors[i])
+        }
+    }
+    board := tl.ToBoard(2)
+    for i, ns := range board.Neighbors {
+        for _, j := range ns {
+            if !ai.Includes(board.Neighbors[j], i) {
+                t.Errorf("%d neighbors %d but not the other way", i, j)
+            }
+        }
+    }
+}
+
+func TestParsePlan(t *testing.T) {
+    _, err := ParsePlan(`[{"typ":"grow","sav":[{"n":3}]}]`)
+    if err == nil {
+        t.Errorf("expected error for unknown round type")
+    }
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+    "errors"
+)
+
+// Takebacks
+// A player sends UndoRequest, every other human seat answers with UndoAccept
+// or UndoDecline, and AI seats answer right away by the game's UndoPolicy
+// Once everyone accepted the history goes back to just before the requester's
+// last move, so it is their turn again, and the position is broadcast as Undo
+// A request goes stale when anyone moves before it is settled
+
+type UndoPolicy string
+
+const (
+    UndoAlways UndoPolicy = "always"
+    UndoNever UndoPolicy = "never"
+    // One takeback for each human seat per game
+    UndoOnce UndoPolicy = "once"
+)
+
+// Empty means always
+func ParseUndoPolicy(policy string) (UndoPolicy, error) {
+    switch UndoPolicy(policy) {
+        case "", UndoAlways:
+            return UndoAlways, nil
+        case UndoNever, UndoOnce:
+            return UndoPolicy(policy), nil
+    }
+    return "", errors.New("Unknown undo policy " + policy)
+}
+
+type UndoRequest struct {
+    Player int
+    // Length of the history when it was asked for
+    Moves int
+    Accepted []bool
+}
+
+// Length of the history after undoing player's last move, -1 if they haven't moved
+// Call with the game locked
+func (game *Game) UndoTarget(player int) int {
+    for i := len(game.History)-1; i > 0; i-- {
+        board := game.History[i-1]
+        if board.Turn % board.NPlayers == player {
+            return i
+        }
+    }
+    return -1
+}
+
+// Call with the game locked
+func (game *Game) AIAcceptsUndo(player int) bool {
+    switch game.UndoPolicy {
+        case UndoNever:
+            return false
+        case UndoOnce:
+            return game.Undos[player] == 0
+    }
+    return true
+}
+
+// Call with the game locked
+func (game *Game) RequestUndo(player int) error {
+    if game.IsOver() || game.State == Scoring {
+        return errors.New("The game is over")
+    }
+    if game.UndoTarget(player) == -1 {
+        return errors.New("Nothing to undo")
+    }
+    if game.PendingUndo != nil && game.PendingUndo.Moves == len(game.History) {
+        return errors.New("An undo is already waiting for an answer")
+    }
+    accepted := make([]bool, len(game.Players))
+    accepted[player] = true
+    for seat, name := range game.Players {
+        if name == "" || seat == player {
+            continue
+        }
+        if !game.AIAcceptsUndo(player) {
+            game.PendingUndo = nil
+            game.Broadcast(Request{Action: "UndoDecline", Key: game.Key, Player: Title(colors[seat]), Payload: Title(colors[player])})
+            return nil
+        }
+        accepted[seat] = true
+    }
+    game.PendingUndo = &UndoRequest{Player: player, Moves: len(game.History), Accepted: accepted}
+    game.Broadcast(Request{Action: "UndoRequest", Key: game.Key, Player: Title(colors[player])})
+    game.CheckUndo()
+    return nil
+}
+
+// Accept or decline the pending request
+// Call with the game locked
+func (game *Game) AnswerUndo(player int, accept bool) error {
+    undo := game.PendingUndo
+    if undo == nil || undo.Moves != len(game.History) || game.IsOver() || game.State == Scoring {
+        game.PendingUndo = nil
+        return errors.New("No undo to answer")
+    }
+    if player == undo.Player {
+        return errors.New("Can't answer your own undo")
+    }
+    if !accept {
+        game.PendingUndo = nil
+        game.Broadcast(Request{Action: "UndoDecline", Key: game.Key, Player: Title(colors[player]), Payload: Title(colors[undo.Player])})
+        return nil
+    }
+    undo.Accepted[player] = true
+    game.CheckUndo()
+    return nil
+}
+
+// Take the moves back once every seat accepted
+// Call with the game locked
+func (game *Game) CheckUndo() {
+    undo := game.PendingUndo
+    for _, accepted := range undo.Accepted {
+        if !accepted {
+            return
+        }
+    }
+    game.PendingUndo = nil
+    game.Undos[undo.Player] += 1
+    game.History = game.History[:game.UndoTarget(undo.Player)]
+    board := game.History[len(game.History)-1]
+    game.Json = BoardToJson(board)
+    game.Player = colors[board.Turn % board.NPlayers]
+    if !game.turnStart.IsZero() {
+        game.StartTurn()
+    }
+    game.Broadcast(Request{Action: "Undo", Key: game.Key, Payload: game.Json, Player: game.Player, Clocks: game.ClockState()})
+    game.Save()
+    // A search in progress is for a position that is gone
+    select {
+        case game.wake <- struct{}{}:
+        default:
+    }
+}